@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"image"
 	"log"
 	"math/rand"
 	"os"
@@ -11,7 +12,6 @@ import (
 	"strconv"
 	"strings"
 	"time"
-	"errors"
 
 	"./primitive"
 	"github.com/nfnt/resize"
@@ -20,6 +20,10 @@ import (
 
 var (
 	Input      string
+	Mask       string
+	Saliency   bool
+	Region     string
+	SaliencyPath string
 	Outputs    flagArray
 	Background string
 	Configs    shapeConfigArray
@@ -32,10 +36,36 @@ var (
 	InputSize  int
 	OutputSize int
 	Mode       string
+	Metric     string
+	Blend      string
 	Workers    int
 	Nth        int
 	Repeat     int
 	Seed  int64
+	TargetScore float64
+	TimeLimit  time.Duration
+	Plateau    string
+	Levels     int
+	Linear     bool
+	Palette    string
+	AutoPalette int
+	Tone       string
+	Inks       string
+	LayerOutput string
+	Symmetry   string
+	Orientation string
+	OrientationTolerance float64
+	SizeMax    string
+	SizeMin    string
+	SizeDecay  string
+	Lattice    string
+	LowPoly    string
+	MixPolicy  string
+	Pack       bool
+	PackOverlap float64
+	PaletteOutputs flagArray
+	Placement  string
+	HeatmapPath string
 	V, VV      bool
 )
 
@@ -55,6 +85,8 @@ type shapeConfig struct {
 	Mode   string
 	Alpha  int
 	Repeat int
+	Metric string
+	Blend  string
 }
 
 type shapeConfigArray []shapeConfig
@@ -65,21 +97,48 @@ func (i *shapeConfigArray) String() string {
 
 func (i *shapeConfigArray) Set(value string) error {
 	n, _ := strconv.ParseInt(value, 0, 0)
-	*i = append(*i, shapeConfig{int(n), Mode, Alpha, Repeat})
+	*i = append(*i, shapeConfig{int(n), Mode, Alpha, Repeat, Metric, Blend})
 	return nil
 }
 
 func init() {
 	flag.StringVar(&Input, "i", "", "input image path")
+	flag.StringVar(&Mask, "mask", "", "grayscale weight mask path; brighter pixels count more toward the error")
+	flag.StringVar(&Region, "roi", "", "region of interest mask path; shapes only cover its white pixels and the rest of the target is kept")
+	flag.BoolVar(&Saliency, "saliency", false, "weight the error by an automatic saliency map instead of a mask")
+	flag.StringVar(&SaliencyPath, "saliency-out", "", "write the weights used (mask or saliency map) to this PNG path")
 	flag.Var(&Outputs, "o", "output image path")
 	flag.Var(&Configs, "n", "number of primitives")
-	flag.StringVar(&Background, "bg", "", "background color (hex)")
+	flag.StringVar(&Background, "bg", "", "background color (hex), or transparent")
 	flag.IntVar(&Alpha, "a", 128, "alpha value")
 	flag.Float64Var(&BlackThresh, "kt", 0.0, "black cut off threshold")
 	flag.StringVar(&AreaThresh, "at", "0.0", "area cut off threshold. Can specify a single value for upper threshold, or comma separated values for both lower and upper thresholds")
 	flag.IntVar(&InputSize, "r", 256, "resize large input images to this size")
 	flag.IntVar(&OutputSize, "s", 1024, "output image size")
-	flag.StringVar(&Mode, "m", "1", "0=combo 1=triangle 2=rect 3=ellipse 4=circle 5=rotatedrect 6=beziers 7=rotatedellipse 8=polygon 9=right-facing-triangle 10=diamond 11=blue-dot-sessions")
+	flag.StringVar(&Mode, "m", "1", "0=combo 1=triangle 2=rect 3=ellipse 4=circle 5=rotatedrect 6=beziers 7=rotatedellipse 8=polygon 9=right-facing-triangle 10=diamond 11=blue-dot-sessions mixture given as 11,MODE:WEIGHT[:KEY=VALUE...],... with keys alpha, size, order, convex, angle and area 12=tile (requires -lattice)")
+	flag.StringVar(&Palette, "palette", "", "restrict shape colors to a palette: hex colors separated by commas, or a .gpl, .ase or text palette file")
+	flag.IntVar(&AutoPalette, "auto-palette", 0, "restrict shape colors to a palette of N colors extracted from the input")
+	flag.Var(&PaletteOutputs, "palette-out", "write the palette used as a swatch (.png) or a list of hex colors (.json)")
+	flag.StringVar(&Tone, "tone", "", "render in tones of gray, duotone:DARK,LIGHT (hex colors), or mono or mono:DARK,LIGHT for two colors only")
+	flag.StringVar(&Inks, "inks", "", "print shapes in layers of inks multiplied together: cmyk, cmy or hex colors separated by commas")
+	flag.StringVar(&LayerOutput, "layer-out", "", "write each ink layer as an SVG (put \"%d\" in path for the layer number)")
+	flag.StringVar(&Symmetry, "symmetry", "", "draw shapes with symmetric copies: x or y (mirror), xy (both), rN (N-fold rotation) or dN (N-fold rotation with mirrors)")
+	flag.StringVar(&Orientation, "orient", "", "orient rotated rectangles, rotated ellipses and curves: angle:DEGREES, point:X,Y (fractions of the image size) or flow (along edges)")
+	flag.Float64Var(&OrientationTolerance, "orient-tol", 10, "allowed deviation in degrees from the -orient angle")
+	flag.StringVar(&SizeMax, "size", "", "maximum shape area as a fraction of the image, moving from START to END over each -n, given as START:END")
+	flag.StringVar(&SizeMin, "size-min", "0", "minimum shape area as a fraction of the image, given as START:END; used with -size")
+	flag.StringVar(&SizeDecay, "size-decay", "linear", "how -size moves from start to end: linear or exp")
+	flag.StringVar(&Lattice, "lattice", "", "snap tiles (-m 12) to a lattice of square, hex or triangle cells, or an opaque pixel grid, given as KIND:CELLS[:LEVELS] with CELLS across the width and LEVELS of subdivision")
+	flag.StringVar(&MixPolicy, "mix", "candidate", "how often a mixture (-m 11) draws a shape type: candidate (every random shape), worker (per worker and step) or step (once per step)")
+	flag.StringVar(&LowPoly, "lowpoly", "", "fill the image with a low poly mesh of delaunay triangles or voronoi cells instead of shapes; each -n step adds, moves or removes a point")
+	flag.BoolVar(&Pack, "pack", false, "keep shapes from overlapping each other (e.g. circle packing with -m 4)")
+	flag.Float64Var(&PackOverlap, "pack-overlap", 0, "allowed overlap in percent of each new shape's pixels; used with -pack")
+	flag.BoolVar(&Linear, "linear", false, "blend shapes and solve colors in linear light (gamma-correct); the search works on 8-bit sRGB images, so it only approximates linear light in dark tones, while the output is composited exactly")
+	flag.IntVar(&Levels, "levels", 1, "number of resolution levels for coarse-to-fine search (1 searches at full resolution only)")
+	flag.StringVar(&Placement, "place", "uniform", "placement of new random shapes: uniform or error (favor high error regions)")
+	flag.StringVar(&HeatmapPath, "heatmap", "", "write the final per-pixel error heatmap to this PNG path")
+	flag.StringVar(&Metric, "metric", "rgb", "error metric: rgb, lab (CIE76), de2000 (CIEDE2000) or ssim (structural similarity); applies to the following -n")
+	flag.StringVar(&Blend, "blend", "normal", "blend mode: normal, multiply, screen, add or difference; applies to the following -n")
 	flag.IntVar(&Workers, "j", 0, "number of parallel workers (default uses all cores)")
 	flag.IntVar(&Nth, "nth", 1, "save every Nth frame (put \"%d\" in path)")
 	flag.IntVar(&ShapeTrials, "st", 1000, "Number of shapes to generate before applying Hill Climb algorithm")
@@ -87,6 +146,9 @@ func init() {
 	flag.IntVar(&Age, "age", 100, "age parameter for Hill Climb Algorithm")
 	flag.IntVar(&Repeat, "rep", 0, "add N extra shapes per iteration with reduced search")
 	flag.Int64Var(&Seed, "seed", -1, "Random number seed")
+	flag.Float64Var(&TargetScore, "target-score", 0, "stop once the score drops to this value")
+	flag.DurationVar(&TimeLimit, "time-limit", 0, "stop once this much time has elapsed (e.g. 30s)")
+	flag.StringVar(&Plateau, "plateau", "", "stop when N consecutive shapes each improve the score by less than eps, given as N:eps")
 	flag.BoolVar(&V, "v", false, "verbose")
 	flag.BoolVar(&VV, "vv", false, "very verbose")
 }
@@ -102,33 +164,19 @@ func check(err error) {
 	}
 }
 
-func parseBlueDotSessionsModeParams (modeStr string) (int, []int, []float64) {
-	split := strings.Split(modeStr, ",")
+// parseBlueDotSessionsModeParams parses a mixture mode: mode 11 followed by
+// the entries of the mixture, separated by commas.
+func parseBlueDotSessionsModeParams(modeStr string) (int, primitive.Mixture, error) {
+	split := strings.SplitN(modeStr, ",", 2)
 	mode, err := strconv.Atoi(split[0])
-	check(err)
-	split = split[1:]
-
-  var modes []int
-  var percs []float64
-
-	var sub_mode int
-	var sub_perc float64
-
-  for _, val := range split {
-    sub_split := strings.Split(val, ":")
-    sub_mode, err = strconv.Atoi(sub_split[0])
-		if (sub_mode == 11) {
-			err = errors.New("Cannot use Blue Dot Sessions mode inside Blue Dot Sessions mode")
-			log.Fatal(err)
-		}
-		check(err)
-    sub_perc, err = strconv.ParseFloat(sub_split[1], 64)
-		check(err)
-    modes = append(modes, sub_mode)
-    percs = append(percs, sub_perc)
-  }
-
-	return mode, modes, percs
+	if err != nil || primitive.ShapeType(mode) != primitive.ShapeTypeBlueDotSessions {
+		return 0, nil, fmt.Errorf("a mode with a mixture must start with %d: %s", primitive.ShapeTypeBlueDotSessions, modeStr)
+	}
+	mixture, err := primitive.ParseMixture(split[1])
+	if err != nil {
+		return 0, nil, err
+	}
+	return mode, mixture, nil
 }
 
 func parseAreaThresh (areaThresh string) (float64, float64) {
@@ -147,30 +195,133 @@ func parseAreaThresh (areaThresh string) (float64, float64) {
 	return 0.0, 0.0
 }
 
+func parsePlateau (plateau string) (int, float64) {
+	if plateau == "" {
+		return 0, 0.0
+	}
+	split := strings.Split(plateau, ":")
+	if len(split) != 2 {
+		log.Fatal(fmt.Errorf("invalid plateau %q, expected N:eps", plateau))
+	}
+	steps, err := strconv.Atoi(split[0])
+	check(err)
+	eps, err := strconv.ParseFloat(split[1], 64)
+	check(err)
+	if steps <= 0 {
+		log.Fatal(fmt.Errorf("invalid plateau %q, N must be > 0", plateau))
+	}
+	if eps < 0 {
+		log.Fatal(fmt.Errorf("invalid plateau %q, eps must be >= 0", plateau))
+	}
+	return steps, eps
+}
+
+// parseSizeSchedule parses the -size, -size-min and -size-decay flags.
+func parseSizeSchedule(max, min, decay string) *primitive.SizeSchedule {
+	var schedule primitive.SizeSchedule
+	var err error
+	schedule.MaxStart, schedule.MaxEnd, err = primitive.ParseSizeRange(max)
+	check(err)
+	schedule.MinStart, schedule.MinEnd, err = primitive.ParseSizeRange(min)
+	check(err)
+	if schedule.MaxStart == 0 || schedule.MaxEnd == 0 {
+		log.Fatal(fmt.Errorf("maximum size must be > 0: %s", max))
+	}
+	switch decay {
+	case "linear":
+	case "exp":
+		schedule.Exponential = true
+	default:
+		log.Fatal(fmt.Errorf("unrecognized size decay: %s", decay))
+	}
+	check(schedule.Validate())
+	return &schedule
+}
+
+// parsePalette reads a palette file if one exists at the given path and
+// otherwise parses the value as a list of hex colors.
+func parsePalette(value string) primitive.Palette {
+	if _, err := os.Stat(value); err == nil {
+		palette, err := primitive.LoadPalette(value)
+		check(err)
+		return palette
+	}
+	palette, err := primitive.ParsePalette(value)
+	check(err)
+	return palette
+}
 
 func main() {
 	// defer profile.Start().Stop()
 	// parse and validate arguments
 	flag.Parse()
+	plateauSteps, plateauEpsilon := parsePlateau(Plateau)
+	stop := primitive.StopCriteria{
+		TargetScore:    TargetScore,
+		TimeLimit:      TimeLimit,
+		PlateauSteps:   plateauSteps,
+		PlateauEpsilon: plateauEpsilon,
+	}
 	ok := true
 	if Input == "" {
 		ok = errorMessage("ERROR: input argument required")
 	}
+	if Mask != "" && Saliency {
+		ok = errorMessage("ERROR: -mask and -saliency cannot be combined")
+	}
+	if Palette != "" && AutoPalette > 0 {
+		ok = errorMessage("ERROR: -palette and -auto-palette cannot be combined")
+	}
+	if Inks != "" && (Palette != "" || AutoPalette > 0 || Tone != "") {
+		ok = errorMessage("ERROR: -inks cannot be combined with -palette, -auto-palette or -tone")
+	}
+	if LayerOutput != "" && Inks == "" {
+		ok = errorMessage("ERROR: -layer-out requires -inks")
+	}
+	if LowPoly != "" {
+		if LowPoly != "delaunay" && LowPoly != "voronoi" {
+			ok = errorMessage("ERROR: -lowpoly must be delaunay or voronoi")
+		}
+		if Symmetry != "" || Pack || Lattice != "" || Inks != "" || Plateau != "" {
+			ok = errorMessage("ERROR: -lowpoly cannot be combined with -symmetry, -pack, -lattice, -inks or -plateau")
+		}
+	}
+	if PackOverlap < 0 || PackOverlap > 100 {
+		ok = errorMessage("ERROR: -pack-overlap must be between 0 and 100")
+	}
 	if len(Outputs) == 0 {
 		ok = errorMessage("ERROR: output argument required")
 	}
 	if len(Configs) == 0 {
-		ok = errorMessage("ERROR: number argument required")
+		if stop.Enabled() {
+			// no shape count, run until a stopping criterion is met
+			Configs = append(Configs, shapeConfig{0, Mode, Alpha, Repeat, Metric, Blend})
+		} else {
+			ok = errorMessage("ERROR: number argument required")
+		}
 	}
 	if len(Configs) == 1 {
 		Configs[0].Mode = Mode
 		Configs[0].Alpha = Alpha
 		Configs[0].Repeat = Repeat
+		Configs[0].Metric = Metric
+		Configs[0].Blend = Blend
 	}
 	for _, config := range Configs {
-		if config.Count < 1 {
+		if config.Count < 1 && !stop.Enabled() {
 			ok = errorMessage("ERROR: number argument must be > 0")
 		}
+		// the size schedule runs over the count of the config
+		if config.Count < 1 && SizeMax != "" && stop.Enabled() {
+			ok = errorMessage("ERROR: -size requires a number of primitives")
+		}
+		if strings.Contains(config.Mode, ",") {
+			if _, _, err := parseBlueDotSessionsModeParams(config.Mode); err != nil {
+				ok = errorMessage("ERROR: " + err.Error())
+			}
+		} else if mode, err := strconv.Atoi(config.Mode); err == nil && primitive.ShapeType(mode) == primitive.ShapeTypeBlueDotSessions {
+			ok = errorMessage(fmt.Sprintf("ERROR: mode %d requires mixture entries, e.g. %d,1:1,3:2", mode, mode))
+		}
 	}
 	if !ok {
 		fmt.Println("Usage: primitive [OPTIONS] -i input -o output -n count")
@@ -209,10 +360,82 @@ func main() {
 		input = resize.Thumbnail(size, size, input, resize.Bilinear)
 	}
 
+	// read weight mask, matching the input size
+	var mask image.Image
+	if Mask != "" {
+		primitive.Log(1, "reading %s\n", Mask)
+		mask, err = primitive.LoadImage(Mask)
+		check(err)
+		bounds := input.Bounds()
+		mask = resize.Resize(uint(bounds.Dx()), uint(bounds.Dy()), mask, resize.Bilinear)
+	}
+
+	// read region of interest, matching the input size
+	var region image.Image
+	if Region != "" {
+		primitive.Log(1, "reading %s\n", Region)
+		region, err = primitive.LoadImage(Region)
+		check(err)
+		bounds := input.Bounds()
+		region = resize.Resize(uint(bounds.Dx()), uint(bounds.Dy()), region, resize.Bilinear)
+	}
+
+	// map the input onto the tone ramp
+	var tone *primitive.Tone
+	if Tone != "" {
+		tone, err = primitive.ParseTone(Tone)
+		check(err)
+		if tone.Mono && (Palette != "" || AutoPalette > 0) {
+			check(fmt.Errorf("a mono tone cannot be combined with a palette"))
+		}
+		input = tone.Image(input)
+	}
+
+	// read palette
+	var palette primitive.Palette
+	if Palette != "" {
+		palette = parsePalette(Palette)
+	} else if AutoPalette > 0 {
+		palette, err = primitive.ExtractPalette(input, AutoPalette)
+		check(err)
+		primitive.Log(1, "extracted %d colors\n", len(palette))
+	}
+	for _, output := range PaletteOutputs {
+		if palette == nil {
+			check(fmt.Errorf("-palette-out requires -palette or -auto-palette"))
+		}
+		primitive.Log(1, "writing %s\n", output)
+		switch ext := strings.ToLower(filepath.Ext(output)); ext {
+		case ".png":
+			check(primitive.SavePNG(output, palette.Swatch(64)))
+		case ".json":
+			check(primitive.SaveFile(output, palette.JSON()))
+		default:
+			check(fmt.Errorf("unrecognized palette file extension: %s", ext))
+		}
+	}
+
+	// read inks
+	var inks primitive.Palette
+	if Inks != "" {
+		inks, err = primitive.ParseInks(Inks)
+		check(err)
+	}
+
 	// determine background color
 	var bg primitive.Color
-	if Background == "" {
+	if Background == "" && inks != nil {
+		// print on white paper
+		bg = primitive.MakeHexColor("#ffffff")
+	} else if Background == "" {
 		bg = primitive.MakeColor(primitive.AverageImageColor(input))
+		if palette != nil {
+			bg = palette.Nearest(bg)
+		} else if tone != nil && tone.Mono {
+			bg = primitive.Palette{tone.Dark, tone.Light}.Nearest(bg)
+		}
+	} else if Background == "transparent" {
+		bg = primitive.Color{}
 	} else {
 		bg = primitive.MakeHexColor(Background)
 	}
@@ -221,35 +444,144 @@ func main() {
 	// run algorithm
 	// primitive.Log(1, "Background=%s, bg=%s\n", Background, bg)
 	model := primitive.NewModel(input, bg, OutputSize, Workers, BlackThresh, lowerAreaThresh, upperAreaThresh, Seed)
+	model.Stop = stop
+	model.SetLevels(Levels)
+	model.SetLinear(Linear)
+	model.SetPalette(palette)
+	model.SetTone(tone)
+	model.SetInks(inks)
+	symmetry, err := primitive.ParseSymmetry(Symmetry)
+	check(err)
+	model.SetSymmetry(symmetry)
+	if Orientation != "" {
+		orientation, err := primitive.ParseOrientation(Orientation, OrientationTolerance)
+		check(err)
+		model.SetOrientation(orientation)
+	}
+	var lattice *primitive.Lattice
+	if Lattice != "" {
+		lattice, err = primitive.ParseLattice(Lattice)
+		check(err)
+		model.SetLattice(lattice)
+	}
+	mixPolicy, err := primitive.ParseMixturePolicy(MixPolicy)
+	check(err)
+	model.SetMixturePolicy(mixPolicy)
+	if Pack {
+		model.SetPacking(PackOverlap / 100)
+	}
+	var schedule *primitive.SizeSchedule
+	if SizeMax != "" {
+		schedule = parseSizeSchedule(SizeMax, SizeMin, SizeDecay)
+		model.SetSizeSchedule(schedule)
+	}
+	if region != nil {
+		check(model.SetRegion(primitive.RegionFromImage(region)))
+	}
+	if mask != nil {
+		check(model.SetWeights(primitive.WeightsFromImage(mask)))
+	} else if Saliency {
+		check(model.SetWeights(primitive.Saliency(model.Target)))
+	}
+	if SaliencyPath != "" && model.Weights != nil {
+		primitive.Log(1, "writing %s\n", SaliencyPath)
+		check(primitive.SavePNG(SaliencyPath, model.Weights))
+	}
+	switch Placement {
+	case "uniform":
+		model.SetPlacement(primitive.PlacementUniform)
+	case "error":
+		model.SetPlacement(primitive.PlacementError)
+	default:
+		check(fmt.Errorf("unrecognized placement: %s", Placement))
+	}
+	var lowpoly *primitive.LowPoly
+	if LowPoly != "" {
+		lowpoly = primitive.NewLowPoly(model, LowPoly == "voronoi")
+	}
 	primitive.Log(1, "%d: t=%.3f, score=%.6f\n", 0, 0.0, model.Score)
 	start := time.Now()
 	frame := 0
-	var mode int
-	var modes []int
-	var percs []float64
+	// number of steps won by each shape type of a mixture
+	wins := make(map[primitive.ShapeType]int)
+	done := false
 
 	for j, config := range Configs {
-		primitive.Log(1, "count=%d, mode=%s, alpha=%d, repeat=%d\n",
-			config.Count, config.Mode, config.Alpha, config.Repeat)
+		if done {
+			break
+		}
+		if lattice != nil && lattice.Kind == primitive.LatticePixel {
+			// pixel art has no translucent pixels
+			config.Alpha = 255
+		}
+		primitive.Log(1, "count=%d, mode=%s, alpha=%d, repeat=%d, metric=%s, blend=%s\n",
+			config.Count, config.Mode, config.Alpha, config.Repeat, config.Metric, config.Blend)
 
+		metric, err := primitive.ParseMetric(config.Metric)
+		check(err)
+		if _, ok := metric.(*primitive.RGBMetric); ok && tone != nil {
+			metric = tone.Metric()
+		}
+		model.SetMetric(metric)
+
+		blend, err := primitive.ParseBlendMode(config.Blend)
+		check(err)
+		if inks != nil {
+			// inks always multiply
+			blend = primitive.BlendMultiply
+		}
+		model.SetBlend(blend)
+
+		var mode int
+		var mixture primitive.Mixture
 		if (strings.IndexAny(config.Mode, ",") != -1) {
-			mode, modes, percs = parseBlueDotSessionsModeParams(config.Mode)
+			mode, mixture, err = parseBlueDotSessionsModeParams(config.Mode)
+			check(err)
 		} else {
 			mode, err = strconv.Atoi(config.Mode)
 			check(err)
 		}
-		newShapeFunc := primitive.NewBlueDotSessionsShapeFactory(modes, percs)
+		if lattice == nil {
+			if primitive.ShapeType(mode) == primitive.ShapeTypeTile {
+				check(fmt.Errorf("tiles require -lattice"))
+			}
+			for _, entry := range mixture {
+				if entry.Type == primitive.ShapeTypeTile {
+					check(fmt.Errorf("tiles require -lattice"))
+				}
+			}
+		}
+		newShapeFunc := primitive.NewBlueDotSessionsShapeFactory(mixture)
+		if schedule != nil {
+			// sizes run from start to end over each block of shapes
+			schedule.Count = config.Count
+		}
 		primitive.Log(1, "parsed mode=%d\n",  mode)
 
 
-		for i := 0; i < config.Count; i++ {
+		// a count of zero means keep going until a stopping criterion is met
+		for i := 0; config.Count < 1 || i < config.Count; i++ {
 			frame++
 			// find optimal shape and add it to the model
 			t := time.Now()
-			n := model.Step(primitive.ShapeType(mode), config.Alpha, config.Repeat, i, ShapeTrials, Age, HillClimbTrials, newShapeFunc)
+			var n int
+			added := true
+			if lowpoly != nil {
+				n = lowpoly.Step()
+			} else {
+				n, added = model.Step(primitive.ShapeType(mode), config.Alpha, config.Repeat, i, ShapeTrials, Age, HillClimbTrials, newShapeFunc)
+			}
 			nps := primitive.NumberString(float64(n) / time.Since(t).Seconds())
 			elapsed := time.Since(start).Seconds()
 			primitive.Log(1, "%d: t=%.3f, score=%.6f, n=%d, n/s=%s\n", frame, elapsed, model.Score, n, nps)
+			if added && lowpoly == nil && primitive.ShapeType(mode) == primitive.ShapeTypeBlueDotSessions {
+				wins[model.Winner]++
+				primitive.Log(1, "%d: type=%s\n", frame, model.Winner)
+			}
+			// stop once every candidate is rejected, e.g. when none fits
+			// between packed shapes
+			full := !added
+			done = full || model.Done()
 
 			// write output image(s)
 			for _, output := range Outputs {
@@ -260,7 +592,7 @@ func main() {
 				percent := strings.Contains(output, "%")
 				saveFrames := percent && ext != ".gif"
 				saveFrames = saveFrames && frame%Nth == 0
-				last := j == len(Configs)-1 && i == config.Count-1
+				last := done || (j == len(Configs)-1 && i == config.Count-1)
 				if saveFrames || last {
 					path := output
 					if percent {
@@ -271,9 +603,9 @@ func main() {
 					default:
 						check(fmt.Errorf("unrecognized file extension: %s", ext))
 					case ".png":
-						check(primitive.SavePNG(path, model.Context.Image()))
+						check(primitive.SavePNG(path, model.Image()))
 					case ".jpg", ".jpeg":
-						check(primitive.SaveJPG(path, model.Context.Image(), 95))
+						check(primitive.SaveJPG(path, model.Image(), 95))
 					case ".svg":
 						check(primitive.SaveFile(path, model.SVG()))
 					case ".gif":
@@ -282,6 +614,43 @@ func main() {
 					}
 				}
 			}
+			if full {
+				fmt.Fprintf(os.Stderr, "no shape could be added after %d shapes\n", len(model.Shapes))
+				break
+			}
+			if done {
+				primitive.Log(1, "stopping criterion met after %d steps\n", frame)
+				break
+			}
+		}
+	}
+
+	if len(wins) > 0 {
+		var counts []string
+		for t := primitive.ShapeTypeAny; t <= primitive.ShapeTypeTile; t++ {
+			if wins[t] > 0 {
+				counts = append(counts, fmt.Sprintf("%s=%d", t, wins[t]))
+			}
 		}
+		primitive.Log(1, "steps won by type: %s\n", strings.Join(counts, ", "))
+	}
+
+	if LayerOutput != "" {
+		for i := range inks {
+			path := LayerOutput
+			if strings.Contains(path, "%") {
+				path = fmt.Sprintf(path, i+1)
+			} else {
+				ext := filepath.Ext(path)
+				path = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), i+1, ext)
+			}
+			primitive.Log(1, "writing %s\n", path)
+			check(primitive.SaveFile(path, model.LayerSVG(i)))
+		}
+	}
+
+	if HeatmapPath != "" {
+		primitive.Log(1, "writing %s\n", HeatmapPath)
+		check(primitive.SavePNG(HeatmapPath, model.Heatmap().Image(0.5)))
 	}
 }
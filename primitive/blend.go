@@ -0,0 +1,384 @@
+package primitive
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+// BlendMode selects how a shape's color combines with the pixels beneath it
+// before being alpha composited, following the W3C compositing spec.
+type BlendMode int
+
+const (
+	BlendNormal BlendMode = iota
+	BlendMultiply
+	BlendScreen
+	BlendAdd
+	BlendDifference
+)
+
+// ParseBlendMode returns the blend mode with the given name: normal,
+// multiply, screen, add or difference.
+func ParseBlendMode(name string) (BlendMode, error) {
+	switch name {
+	case "", "normal":
+		return BlendNormal, nil
+	case "multiply":
+		return BlendMultiply, nil
+	case "screen":
+		return BlendScreen, nil
+	case "add", "additive":
+		return BlendAdd, nil
+	case "difference":
+		return BlendDifference, nil
+	}
+	return 0, fmt.Errorf("unrecognized blend mode: %s", name)
+}
+
+// CSS returns the name of the mode as a CSS mix-blend-mode value.
+func (mode BlendMode) CSS() string {
+	switch mode {
+	case BlendMultiply:
+		return "multiply"
+	case BlendScreen:
+		return "screen"
+	case BlendAdd:
+		return "plus-lighter"
+	case BlendDifference:
+		return "difference"
+	}
+	return "normal"
+}
+
+// blend combines a source and a backdrop channel value in [0, 1].
+func (mode BlendMode) blend(s, d float64) float64 {
+	switch mode {
+	case BlendMultiply:
+		return s * d
+	case BlendScreen:
+		return s + d - s*d
+	case BlendAdd:
+		return math.Min(s+d, 1)
+	case BlendDifference:
+		return math.Abs(s - d)
+	}
+	return s
+}
+
+// linearTerms returns p and q such that blend(s, d) = p*s + q, ignoring the
+// clamping of BlendAdd. Difference is not linear in s.
+func (mode BlendMode) linearTerms(d float64) (p, q float64) {
+	switch mode {
+	case BlendMultiply:
+		return d, 0
+	case BlendScreen:
+		return 1 - d, d
+	case BlendAdd:
+		return 1, d
+	}
+	return 1, 0
+}
+
+// composite draws source channel s with alpha a over the premultiplied
+// backdrop channel d with alpha da and returns the premultiplied result.
+func (mode BlendMode) composite(s, a, d, da float64) float64 {
+	if da == 1 {
+		return a*mode.blend(s, d) + (1-a)*d
+	}
+	var cb float64
+	if da > 0 {
+		cb = d / da
+	}
+	cs := (1-da)*s + da*mode.blend(s, cb)
+	return a*cs + (1-a)*d
+}
+
+// unit8 maps 8-bit values to [0, 1].
+var unit8 [256]float64
+
+func init() {
+	for i := range unit8 {
+		unit8[i] = float64(i) / 255
+	}
+}
+
+// decodeChannel converts an 8-bit sRGB component to [0, 1], in linear light
+// when linear is set.
+func decodeChannel(c int, linear bool) float64 {
+	if linear {
+		return srgbLinear[c]
+	}
+	return unit8[c]
+}
+
+// encodeChannel is the inverse of decodeChannel.
+func encodeChannel(v float64, linear bool) int {
+	if linear {
+		return int(encodeLinear(v))
+	}
+	return int(clamp(v, 0, 1)*255 + 0.5)
+}
+
+// pixelSpace returns the premultiplied color and the alpha of the pixel at
+// the start of p in [0, 1], in linear light when linear is set.
+func pixelSpace(p []uint8, linear bool) (r, g, b, a float64) {
+	if linear {
+		return pixelLinear(p)
+	}
+	return unit8[p[0]], unit8[p[1]], unit8[p[2]], unit8[p[3]]
+}
+
+// setPixelSpace is the inverse of pixelSpace.
+func setPixelSpace(p []uint8, r, g, b, a float64, linear bool) {
+	if linear {
+		setPixelLinear(p, r, g, b, a)
+		return
+	}
+	a = clamp(a, 0, 1)
+	p[0] = uint8(clamp(r, 0, a)*255 + 0.5)
+	p[1] = uint8(clamp(g, 0, a)*255 + 0.5)
+	p[2] = uint8(clamp(b, 0, a)*255 + 0.5)
+	p[3] = uint8(a*255 + 0.5)
+}
+
+// blendColor returns the color for a shape covering lines. Plain source-over
+// compositing in sRGB is left to the metric, everything else is solved for
+// by computeColorBlend.
+func blendColor(metric Metric, mode BlendMode, linear bool, target, current *image.RGBA, weights *image.Gray, lines []Scanline, alpha int) Color {
+	if mode == BlendNormal && !linear {
+		return metric.Color(target, current, weights, lines, alpha)
+	}
+	return computeColorBlend(target, current, weights, lines, alpha, mode, linear)
+}
+
+// blendLines draws c over lines of im with the given blend mode, in linear
+// light when linear is set.
+func blendLines(im *image.RGBA, c Color, lines []Scanline, mode BlendMode, linear bool) {
+	if mode == BlendNormal && !linear {
+		drawLines(im, c, lines)
+		return
+	}
+	sr := decodeChannel(c.R, linear)
+	sg := decodeChannel(c.G, linear)
+	sb := decodeChannel(c.B, linear)
+	sa := float64(c.A) / 255
+	for _, line := range lines {
+		a := sa * float64(line.Alpha) / 0xffff
+		i := im.PixOffset(line.X1, line.Y)
+		for x := line.X1; x <= line.X2; x++ {
+			p := im.Pix[i : i+4]
+			dr, dg, db, da := pixelSpace(p, linear)
+			setPixelSpace(p,
+				mode.composite(sr, a, dr, da),
+				mode.composite(sg, a, dg, da),
+				mode.composite(sb, a, db, da),
+				a+da*(1-a), linear)
+			i += 4
+		}
+	}
+}
+
+// computeColorBlend is like computeColor for any blend mode, in linear light
+// when linear is set. Each channel is solved by least squares, which is
+// exact for the modes that are linear in the source color. Difference is
+// handed to computeColorDifference.
+func computeColorBlend(target, current *image.RGBA, weights *image.Gray, lines []Scanline, alpha int, mode BlendMode, linear bool) Color {
+	if mode == BlendDifference {
+		return computeColorDifference(target, current, weights, lines, alpha, linear)
+	}
+	a := float64(alpha) / 255
+	var num, den [3]float64
+	for _, line := range lines {
+		i := target.PixOffset(line.X1, line.Y)
+		j := -1
+		if weights != nil {
+			j = weights.PixOffset(line.X1, line.Y)
+		}
+		for x := line.X1; x <= line.X2; x++ {
+			w := 1.0
+			if j >= 0 {
+				w = float64(weights.Pix[j])
+				j++
+			}
+			tr, tg, tb, ta := pixelSpace(target.Pix[i:], linear)
+			cr, cg, cb, ca := pixelSpace(current.Pix[i:], linear)
+			i += 4
+			if ta == 0 || w == 0 {
+				// fully transparent pixels have no color to match
+				continue
+			}
+			// match the target's unpremultiplied color at the alpha the
+			// result will have
+			k := (a + ca*(1-a)) / ta
+			t := [3]float64{tr * k, tg * k, tb * k}
+			c := [3]float64{cr, cg, cb}
+			for ch := 0; ch < 3; ch++ {
+				d := c[ch]
+				if ca != 1 && ca > 0 {
+					d /= ca
+				}
+				p, q := mode.linearTerms(d)
+				P := (1 - ca) + ca*p
+				Q := ca * q
+				num[ch] += w * P * (t[ch] - (1-a)*c[ch] - a*Q)
+				den[ch] += w * a * P * P
+			}
+		}
+	}
+	var rgb [3]int
+	for ch := 0; ch < 3; ch++ {
+		if den[ch] > 0 {
+			rgb[ch] = encodeChannel(num[ch]/den[ch], linear)
+		}
+	}
+	return Color{rgb[0], rgb[1], rgb[2], alpha}
+}
+
+// computeColorDifference solves for the color of a shape drawn in difference
+// mode by trying every 8-bit value per channel, treating the canvas as
+// opaque.
+func computeColorDifference(target, current *image.RGBA, weights *image.Gray, lines []Scanline, alpha int, linear bool) Color {
+	a := float64(alpha) / 255
+	// per backdrop value, the sums of w*u and w*u*d, where u is the blend
+	// result each pixel needs
+	var hu, hud [3][256]float64
+	var sw, swd [3]float64
+	for _, line := range lines {
+		i := target.PixOffset(line.X1, line.Y)
+		j := -1
+		if weights != nil {
+			j = weights.PixOffset(line.X1, line.Y)
+		}
+		for x := line.X1; x <= line.X2; x++ {
+			w := 1.0
+			if j >= 0 {
+				w = float64(weights.Pix[j])
+				j++
+			}
+			tr, tg, tb, ta := pixelSpace(target.Pix[i:], linear)
+			cr, cg, cb, _ := pixelSpace(current.Pix[i:], linear)
+			if ta == 0 || w == 0 {
+				i += 4
+				continue
+			}
+			k := 1 / ta
+			t := [3]float64{tr * k, tg * k, tb * k}
+			c := [3]float64{cr, cg, cb}
+			for ch := 0; ch < 3; ch++ {
+				d := c[ch]
+				u := (t[ch] - (1-a)*d) / a
+				bin := int(current.Pix[i+ch])
+				hu[ch][bin] += w * u
+				hud[ch][bin] += w * u * d
+				sw[ch] += w
+				swd[ch] += w * d
+			}
+			i += 4
+		}
+	}
+	var rgb [3]int
+	for ch := 0; ch < 3; ch++ {
+		rgb[ch] = solveDifference(&hu[ch], &hud[ch], sw[ch], swd[ch], linear)
+	}
+	return Color{rgb[0], rgb[1], rgb[2], alpha}
+}
+
+// solveDifference returns the 8-bit source value s minimizing
+// sum w*(|s-d| - u)^2, given per backdrop value histograms of w*u and w*u*d
+// and the sums of w and w*d.
+func solveDifference(hu, hud *[256]float64, sw, swd float64, linear bool) int {
+	var totalU, totalUD float64
+	for i := range hu {
+		totalU += hu[i]
+		totalUD += hud[i]
+	}
+	best := 0
+	bestCost := math.Inf(1)
+	var belowU, belowUD float64
+	for i := 0; i < 256; i++ {
+		s := decodeChannel(i, linear)
+		// sum of w*u*|s-d|, splitting at backdrop values below s
+		abs := (s*belowU - belowUD) + ((totalUD - belowUD) - s*(totalU-belowU))
+		cost := sw*s*s - 2*s*swd - 2*abs
+		if cost < bestCost {
+			bestCost = cost
+			best = i
+		}
+		belowU += hu[i]
+		belowUD += hud[i]
+	}
+	return best
+}
+
+// canvas renders shapes at the output size for the blend modes and linear
+// light compositing that gg does not support.
+type canvas struct {
+	W, H   int
+	Linear bool
+	Pix    []float32 // premultiplied RGBA in [0, 1]
+	mask   *gg.Context
+}
+
+func newCanvas(w, h int, scale float64, background Color, linear bool) *canvas {
+	c := &canvas{}
+	c.W = w
+	c.H = h
+	c.Linear = linear
+	c.Pix = make([]float32, w*h*4)
+	a := float64(background.A) / 255
+	r := float32(decodeChannel(background.R, linear) * a)
+	g := float32(decodeChannel(background.G, linear) * a)
+	b := float32(decodeChannel(background.B, linear) * a)
+	for i := 0; i < len(c.Pix); i += 4 {
+		c.Pix[i], c.Pix[i+1], c.Pix[i+2], c.Pix[i+3] = r, g, b, float32(a)
+	}
+	c.mask = gg.NewContext(w, h)
+	c.mask.Scale(scale, scale)
+	c.mask.Translate(0.5, 0.5)
+	return c
+}
+
+// Draw blends a shape of the given color onto the canvas, using gg to find
+// the coverage of each pixel.
+func (c *canvas) Draw(shape Shape, color Color, mode BlendMode, scale float64) {
+	c.mask.SetRGBA(0, 0, 0, 0)
+	c.mask.Clear()
+	c.mask.SetRGBA(1, 1, 1, 1)
+	shape.Draw(c.mask, scale)
+	coverage := c.mask.Image().(*image.RGBA).Pix
+	sr := decodeChannel(color.R, c.Linear)
+	sg := decodeChannel(color.G, c.Linear)
+	sb := decodeChannel(color.B, c.Linear)
+	sa := float64(color.A) / 255
+	for i := 3; i < len(coverage); i += 4 {
+		if coverage[i] == 0 {
+			continue
+		}
+		a := sa * float64(coverage[i]) / 255
+		p := c.Pix[i-3 : i+1]
+		da := float64(p[3])
+		p[0] = float32(mode.composite(sr, a, float64(p[0]), da))
+		p[1] = float32(mode.composite(sg, a, float64(p[1]), da))
+		p[2] = float32(mode.composite(sb, a, float64(p[2]), da))
+		p[3] = float32(a + da*(1-a))
+	}
+}
+
+// Image converts the canvas to 8-bit sRGB.
+func (c *canvas) Image() *image.NRGBA {
+	im := image.NewNRGBA(image.Rect(0, 0, c.W, c.H))
+	for i := 0; i < len(c.Pix); i += 4 {
+		a := float64(c.Pix[i+3])
+		if a <= 0 {
+			continue
+		}
+		im.Pix[i] = uint8(encodeChannel(float64(c.Pix[i])/a, c.Linear))
+		im.Pix[i+1] = uint8(encodeChannel(float64(c.Pix[i+1])/a, c.Linear))
+		im.Pix[i+2] = uint8(encodeChannel(float64(c.Pix[i+2])/a, c.Linear))
+		im.Pix[i+3] = uint8(clamp(a, 0, 1)*255 + 0.5)
+	}
+	return im
+}
@@ -2,29 +2,50 @@ package primitive
 
 import (
 	"image"
-	"math"
 )
 
 
 
 
-func computeColor(target, current *image.RGBA, lines []Scanline, alpha int) Color {
+func computeColor(target, current *image.RGBA, weights *image.Gray, lines []Scanline, alpha int) Color {
 	var rsum, gsum, bsum, count int64
 	a := 0x101 * 255 / alpha
 	for _, line := range lines {
 		i := target.PixOffset(line.X1, line.Y)
+		j := -1
+		if weights != nil {
+			j = weights.PixOffset(line.X1, line.Y)
+		}
 		for x := line.X1; x <= line.X2; x++ {
 			tr := int(target.Pix[i])
 			tg := int(target.Pix[i+1])
 			tb := int(target.Pix[i+2])
+			ta := target.Pix[i+3]
 			cr := int(current.Pix[i])
 			cg := int(current.Pix[i+1])
 			cb := int(current.Pix[i+2])
+			if ca := int(current.Pix[i+3]); ta != 0 && (ta != 255 || ca != 255) {
+				// match the target's unpremultiplied color at the alpha the
+				// result will have
+				oa := alpha + ca*(255-alpha)/255
+				tr = tr * oa / int(ta)
+				tg = tg * oa / int(ta)
+				tb = tb * oa / int(ta)
+			}
 			i += 4
-			rsum += int64((tr-cr)*a + cr*0x101)
-			gsum += int64((tg-cg)*a + cg*0x101)
-			bsum += int64((tb-cb)*a + cb*0x101)
-			count++
+			w := int64(1)
+			if j >= 0 {
+				w = int64(weights.Pix[j])
+				j++
+			}
+			if ta == 0 {
+				// fully transparent pixels have no color to match
+				w = 0
+			}
+			rsum += w * int64((tr-cr)*a+cr*0x101)
+			gsum += w * int64((tg-cg)*a+cg*0x101)
+			bsum += w * int64((tb-cb)*a+cb*0x101)
+			count += w
 		}
 	}
 	if count == 0 {
@@ -64,64 +85,3 @@ func drawLines(im *image.RGBA, c Color, lines []Scanline) {
 		}
 	}
 }
-
-func differenceFull(a, b *image.RGBA) float64 {
-	size := a.Bounds().Size()
-	w, h := size.X, size.Y
-	var total uint64
-	for y := 0; y < h; y++ {
-		i := a.PixOffset(0, y)
-		for x := 0; x < w; x++ {
-			ar := int(a.Pix[i])
-			ag := int(a.Pix[i+1])
-			ab := int(a.Pix[i+2])
-			aa := int(a.Pix[i+3])
-			br := int(b.Pix[i])
-			bg := int(b.Pix[i+1])
-			bb := int(b.Pix[i+2])
-			ba := int(b.Pix[i+3])
-			i += 4
-			dr := ar - br
-			dg := ag - bg
-			db := ab - bb
-			da := aa - ba
-			total += uint64(dr*dr + dg*dg + db*db + da*da)
-		}
-	}
-	return math.Sqrt(float64(total)/float64(w*h*4)) / 255
-}
-
-func differencePartial(target, before, after *image.RGBA, score float64, lines []Scanline) float64 {
-	size := target.Bounds().Size()
-	w, h := size.X, size.Y
-	total := uint64(math.Pow(score*255, 2) * float64(w*h*4))
-	for _, line := range lines {
-		i := target.PixOffset(line.X1, line.Y)
-		for x := line.X1; x <= line.X2; x++ {
-			tr := int(target.Pix[i])
-			tg := int(target.Pix[i+1])
-			tb := int(target.Pix[i+2])
-			ta := int(target.Pix[i+3])
-			br := int(before.Pix[i])
-			bg := int(before.Pix[i+1])
-			bb := int(before.Pix[i+2])
-			ba := int(before.Pix[i+3])
-			ar := int(after.Pix[i])
-			ag := int(after.Pix[i+1])
-			ab := int(after.Pix[i+2])
-			aa := int(after.Pix[i+3])
-			i += 4
-			dr1 := tr - br
-			dg1 := tg - bg
-			db1 := tb - bb
-			da1 := ta - ba
-			dr2 := tr - ar
-			dg2 := tg - ag
-			db2 := tb - ab
-			da2 := ta - aa
-			total -= uint64(dr1*dr1 + dg1*dg1 + db1*db1 + da1*da1)
-			total += uint64(dr2*dr2 + dg2*dg2 + db2*db2 + da2*da2)
-		}
-	}
-	return math.Sqrt(float64(total)/float64(w*h*4)) / 255
-}
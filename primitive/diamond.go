@@ -13,13 +13,8 @@ func NewRandomDiamond(worker *Worker, order int, convex bool, minAngle, sizeFact
 	var p *Polygon
 
 	if order == 4 {
-		w := worker.W
-		h := worker.H
-		rnd := worker.Rnd
-
-		x0 := rnd.Float64() * float64(w)
-		y0 := rnd.Float64() * float64(h)
-		x, y := Init(worker, x0, y0, sizeFactor)
+		x0, y0 := worker.RandomPointF()
+		x, y := Init(worker, x0, y0, worker.maxSize(sizeFactor, 1))
 		// vv("NewRandomDiamond: x=%f, y=%f\n", x, y)
 		p = &Polygon{worker, order, convex, minAngle, sizeFactor, boundsFactor, x, y}
 	}
@@ -70,28 +65,30 @@ func (diam *Diamond) Mutate() {
 	w := p.Worker.W
 	h := p.Worker.H
 	rnd := p.Worker.Rnd
-	sizeFactor := p.SizeFactor
+	// SizeFactor is in full resolution pixels
+	sizeFactor := p.SizeFactor * p.Worker.SizeScale
 	boundsFactor := p.BoundsFactor
 	boundsFactorf := float64(boundsFactor)
+	d := p.Worker.mutation(p.SizeFactor)
 	var delta_x1 float64
 	for {
 		idx := rnd.Intn(p.Order)
 		if idx % 2 == 0 {
-			p.X[idx] = clamp(p.X[idx]+rnd.NormFloat64()*sizeFactor, p.X[3], p.X[1])
-			p.Y[idx] = clamp(p.Y[idx]+rnd.NormFloat64()*sizeFactor, -boundsFactorf, float64(h-1+boundsFactor))
+			p.X[idx] = clamp(p.X[idx]+rnd.NormFloat64()*d, p.X[3], p.X[1])
+			p.Y[idx] = clamp(p.Y[idx]+rnd.NormFloat64()*d, -boundsFactorf, float64(h-1+boundsFactor))
 			p.X[(idx + 2) % p.Order] = p.X[idx]
 		} else {
 			if idx == 1 {
-				p.X[idx] = clamp(p.X[idx]+rnd.NormFloat64()*sizeFactor, -boundsFactorf, float64(w-1+boundsFactor))
+				p.X[idx] = clamp(p.X[idx]+rnd.NormFloat64()*d, -boundsFactorf, float64(w-1+boundsFactor))
 			} else if idx == 3 {
 				delta_x1 = p.X[1] - p.X[0]
 				low_bound_3 := p.X[0] - (delta_x1 * 0.5)
 				if low_bound_3 < -boundsFactorf {
 					low_bound_3 = -boundsFactorf
 				}
-				p.X[idx] = clamp(p.X[idx]+rnd.NormFloat64()*sizeFactor, low_bound_3, p.X[0] - 0.1*sizeFactor)
+				p.X[idx] = clamp(p.X[idx]+rnd.NormFloat64()*d, low_bound_3, p.X[0] - 0.1*sizeFactor)
 			}
-			p.Y[idx] = clamp(p.Y[idx]+rnd.NormFloat64()*sizeFactor, p.Y[0] + 0.3*sizeFactor, p.Y[2] - 0.3*sizeFactor)
+			p.Y[idx] = clamp(p.Y[idx]+rnd.NormFloat64()*d, p.Y[0] + 0.3*sizeFactor, p.Y[2] - 0.3*sizeFactor)
 			p.Y[(idx + 2) % p.Order] = p.Y[idx]
 		}
 		if diam.Valid() {
@@ -119,3 +116,13 @@ func (diam *Diamond) Rasterize() []Scanline {
 func (diam *Diamond) Area() float64 {
   return diam.polygon.Area()
 }
+
+func (diam *Diamond) Rescale(worker *Worker, factor float64) Shape {
+	return &Diamond{*diam.polygon.Rescale(worker, factor).(*Polygon)}
+}
+
+// Transform returns a plain polygon, since rotated copies are no longer
+// diamonds.
+func (diam *Diamond) Transform(t Transform) Shape {
+	return diam.polygon.Transform(t)
+}
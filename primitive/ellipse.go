@@ -17,18 +17,17 @@ type Ellipse struct {
 
 func NewRandomEllipse(worker *Worker) *Ellipse {
 	rnd := worker.Rnd
-	x := rnd.Intn(worker.W)
-	y := rnd.Intn(worker.H)
-	rx := rnd.Intn(32) + 1
-	ry := rnd.Intn(32) + 1
+	x, y := worker.RandomPoint()
+	n := int(worker.maxSize(32, math.Pi))
+	rx := rnd.Intn(n) + 1
+	ry := rnd.Intn(n) + 1
 	return &Ellipse{worker, x, y, rx, ry, false}
 }
 
 func NewRandomCircle(worker *Worker) *Ellipse {
 	rnd := worker.Rnd
-	x := rnd.Intn(worker.W)
-	y := rnd.Intn(worker.H)
-	r := rnd.Intn(32) + 1
+	x, y := worker.RandomPoint()
+	r := rnd.Intn(int(worker.maxSize(32, math.Pi))) + 1
 	return &Ellipse{worker, x, y, r, r, true}
 }
 
@@ -52,17 +51,18 @@ func (c *Ellipse) Mutate() {
 	w := c.Worker.W
 	h := c.Worker.H
 	rnd := c.Worker.Rnd
+	d := c.Worker.mutation(16)
 	switch rnd.Intn(3) {
 	case 0:
-		c.X = clampInt(c.X+int(rnd.NormFloat64()*16), 0, w-1)
-		c.Y = clampInt(c.Y+int(rnd.NormFloat64()*16), 0, h-1)
+		c.X = clampInt(c.X+int(rnd.NormFloat64()*d), 0, w-1)
+		c.Y = clampInt(c.Y+int(rnd.NormFloat64()*d), 0, h-1)
 	case 1:
-		c.Rx = clampInt(c.Rx+int(rnd.NormFloat64()*16), 1, w-1)
+		c.Rx = clampInt(c.Rx+int(rnd.NormFloat64()*d), 1, w-1)
 		if c.Circle {
 			c.Ry = c.Rx
 		}
 	case 2:
-		c.Ry = clampInt(c.Ry+int(rnd.NormFloat64()*16), 1, h-1)
+		c.Ry = clampInt(c.Ry+int(rnd.NormFloat64()*d), 1, h-1)
 		if c.Circle {
 			c.Rx = c.Ry
 		}
@@ -96,10 +96,33 @@ func (c *Ellipse) Rasterize() []Scanline {
 			lines = append(lines, Scanline{y2, x1, x2, 0xffff})
 		}
 	}
-	return lines
+	// transformed copies may be centered outside the image
+	return cropScanlines(lines, w, h)
 }
 func (c *Ellipse) Area() float64 {
-	return 1.0
+	return math.Pi * float64(c.Rx*c.Ry)
+}
+
+func (c *Ellipse) Rescale(worker *Worker, factor float64) Shape {
+	a := *c
+	a.Worker = worker
+	a.X, a.Y = scaleInt(c.X, factor), scaleInt(c.Y, factor)
+	a.Rx, a.Ry = scaleInt(c.Rx, factor), scaleInt(c.Ry, factor)
+	return &a
+}
+
+func (c *Ellipse) Transform(t Transform) Shape {
+	if c.Circle || t.axisAligned() {
+		a := *c
+		a.X, a.Y = t.pointInt(c.Worker, c.X, c.Y)
+		if t.quarterTurn() {
+			a.Rx, a.Ry = c.Ry, c.Rx
+		}
+		return &a
+	}
+	// any other rotation needs a rotated ellipse
+	x, y := t.point(c.Worker, float64(c.X), float64(c.Y))
+	return &RotatedEllipse{c.Worker, x, y, float64(c.Rx), float64(c.Ry), t.angle(0)}
 }
 
 
@@ -112,11 +135,15 @@ type RotatedEllipse struct {
 
 func NewRandomRotatedEllipse(worker *Worker) *RotatedEllipse {
 	rnd := worker.Rnd
-	x := rnd.Float64() * float64(worker.W)
-	y := rnd.Float64() * float64(worker.H)
-	rx := rnd.Float64()*32 + 1
-	ry := rnd.Float64()*32 + 1
+	x, y := worker.RandomPointF()
+	n := worker.maxSize(32, math.Pi)
+	rx := rnd.Float64()*n + 1
+	ry := rnd.Float64()*n + 1
 	a := rnd.Float64() * 360
+	if worker.Orientation != nil {
+		rx, ry = math.Max(rx, ry), math.Min(rx, ry)
+		a = worker.orient(x, y, a)
+	}
 	return &RotatedEllipse{worker, x, y, rx, ry, a}
 }
 
@@ -143,16 +170,22 @@ func (c *RotatedEllipse) Mutate() {
 	w := c.Worker.W
 	h := c.Worker.H
 	rnd := c.Worker.Rnd
+	d := c.Worker.mutation(16)
 	switch rnd.Intn(3) {
 	case 0:
-		c.X = clamp(c.X+rnd.NormFloat64()*16, 0, float64(w-1))
-		c.Y = clamp(c.Y+rnd.NormFloat64()*16, 0, float64(h-1))
+		c.X = clamp(c.X+rnd.NormFloat64()*d, 0, float64(w-1))
+		c.Y = clamp(c.Y+rnd.NormFloat64()*d, 0, float64(h-1))
 	case 1:
-		c.Rx = clamp(c.Rx+rnd.NormFloat64()*16, 1, float64(w-1))
-		c.Ry = clamp(c.Ry+rnd.NormFloat64()*16, 1, float64(w-1))
+		c.Rx = clamp(c.Rx+rnd.NormFloat64()*d, 1, float64(w-1))
+		c.Ry = clamp(c.Ry+rnd.NormFloat64()*d, 1, float64(w-1))
 	case 2:
-		c.Angle = c.Angle + rnd.NormFloat64()*32
+		c.Angle = c.Angle + rnd.NormFloat64()*2*d
 	}
+	if c.Worker.Orientation != nil && c.Ry > c.Rx {
+		// the long axis is the one that follows the orientation
+		c.Rx, c.Ry = c.Ry, c.Rx
+	}
+	c.Angle = c.Worker.orient(c.X, c.Y, c.Angle)
 }
 
 func (c *RotatedEllipse) Rasterize() []Scanline {
@@ -183,5 +216,20 @@ func (c *RotatedEllipse) Rasterize() []Scanline {
 }
 
 func (c *RotatedEllipse) Area() float64 {
-	return 1.0
+	return math.Pi * c.Rx * c.Ry
+}
+
+func (c *RotatedEllipse) Rescale(worker *Worker, factor float64) Shape {
+	a := *c
+	a.Worker = worker
+	a.X, a.Y = c.X*factor, c.Y*factor
+	a.Rx, a.Ry = c.Rx*factor, c.Ry*factor
+	return &a
+}
+
+func (c *RotatedEllipse) Transform(t Transform) Shape {
+	a := *c
+	a.X, a.Y = t.point(c.Worker, c.X, c.Y)
+	a.Angle = t.angle(c.Angle)
+	return &a
 }
@@ -22,6 +22,24 @@ func (h *Heatmap) Clear() {
 	}
 }
 
+// AddDifference adds the squared per-pixel error between target and current.
+func (h *Heatmap) AddDifference(target, current *image.RGBA) {
+	i := 0
+	for y := 0; y < h.H; y++ {
+		j := target.PixOffset(0, y)
+		for x := 0; x < h.W; x++ {
+			var total int
+			for c := 0; c < 4; c++ {
+				d := int(target.Pix[j+c]) - int(current.Pix[j+c])
+				total += d * d
+			}
+			h.Count[i] += uint64(total)
+			i++
+			j += 4
+		}
+	}
+}
+
 func (h *Heatmap) Add(lines []Scanline) {
 	for _, line := range lines {
 		i := line.Y*h.W + line.X1
@@ -46,6 +64,10 @@ func (h *Heatmap) Image(gamma float64) *image.Gray16 {
 			hi = h
 		}
 	}
+	if hi == 0 {
+		// no error anywhere
+		return im
+	}
 	i := 0
 	for y := 0; y < h.H; y++ {
 		for x := 0; x < h.W; x++ {
@@ -0,0 +1,29 @@
+package primitive
+
+import (
+	"image"
+	"testing"
+)
+
+func TestHeatmapImage(t *testing.T) {
+	target := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	current := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	heatmap := NewHeatmap(8, 4)
+	heatmap.AddDifference(target, current)
+	// an exact match is black
+	for i, p := range heatmap.Image(0.5).Pix {
+		if p != 0 {
+			t.Fatalf("byte %d of the image of no error is %d, want 0", i, p)
+		}
+	}
+	target.Pix[target.PixOffset(3, 2)] = 200
+	heatmap.Clear()
+	heatmap.AddDifference(target, current)
+	im := heatmap.Image(0.5)
+	if g := im.Gray16At(3, 2).Y; g != 0xffff {
+		t.Errorf("pixel with the most error is %d, want %d", g, 0xffff)
+	}
+	if g := im.Gray16At(0, 0).Y; g != 0 {
+		t.Errorf("pixel without error is %d, want 0", g)
+	}
+}
@@ -0,0 +1,169 @@
+package primitive
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+const (
+	// pixels of the target that are sampled for palette extraction
+	kmeansSamples = 16384
+	// maximum number of k-means iterations
+	kmeansIterations = 32
+)
+
+// ExtractPalette derives a palette of up to k colors from the image by
+// k-means clustering of its pixels in CIELAB. Transparent pixels are
+// ignored. The result is deterministic and ordered from dark to light.
+func ExtractPalette(im image.Image, k int) (Palette, error) {
+	if k < 1 {
+		return nil, fmt.Errorf("palette size must be > 0")
+	}
+	src := image.NewNRGBA(im.Bounds())
+	draw.Draw(src, src.Rect, im, im.Bounds().Min, draw.Src)
+
+	// sample opaque enough pixels on a regular stride
+	n := src.Rect.Dx() * src.Rect.Dy()
+	step := maxInt(1, n/kmeansSamples)
+	var points [][3]float64
+	for i := 0; i < n; i += step {
+		p := src.Pix[i*4 : i*4+4]
+		if p[3] < 128 {
+			continue
+		}
+		l, a, b := rgbToLab(p[0], p[1], p[2])
+		points = append(points, [3]float64{l, a, b})
+	}
+	if len(points) == 0 {
+		return nil, fmt.Errorf("image has no opaque pixels")
+	}
+
+	centers := kmeansSeed(points, k, rand.New(rand.NewSource(1)))
+	assignments := make([]int, len(points))
+	counts := make([]int, len(centers))
+	for iteration := 0; iteration < kmeansIterations; iteration++ {
+		changed := false
+		for i, p := range points {
+			j, _ := nearestCenter(centers, p)
+			if j != assignments[i] || iteration == 0 {
+				assignments[i] = j
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+		sums := make([][3]float64, len(centers))
+		for j := range counts {
+			counts[j] = 0
+		}
+		for i, p := range points {
+			j := assignments[i]
+			sums[j][0] += p[0]
+			sums[j][1] += p[1]
+			sums[j][2] += p[2]
+			counts[j]++
+		}
+		for j := range centers {
+			if counts[j] == 0 {
+				continue
+			}
+			c := float64(counts[j])
+			centers[j] = [3]float64{sums[j][0] / c, sums[j][1] / c, sums[j][2] / c}
+		}
+	}
+
+	// drop empty clusters and duplicate colors
+	seen := make(map[Color]bool)
+	var palette Palette
+	for j, center := range centers {
+		if counts[j] == 0 {
+			continue
+		}
+		r, g, b := labToRGB(center[0], center[1], center[2])
+		c := Color{r, g, b, 255}
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		palette = append(palette, c)
+	}
+	sort.SliceStable(palette, func(i, j int) bool {
+		li, _, _ := rgbToLab(uint8(palette[i].R), uint8(palette[i].G), uint8(palette[i].B))
+		lj, _, _ := rgbToLab(uint8(palette[j].R), uint8(palette[j].G), uint8(palette[j].B))
+		return li < lj
+	})
+	return palette, nil
+}
+
+// kmeansSeed picks up to k initial centers with k-means++, so that each
+// center is chosen with probability proportional to its squared distance
+// from the centers chosen so far.
+func kmeansSeed(points [][3]float64, k int, rnd *rand.Rand) [][3]float64 {
+	centers := [][3]float64{points[rnd.Intn(len(points))]}
+	distances := make([]float64, len(points))
+	for len(centers) < k {
+		total := 0.0
+		for i, p := range points {
+			_, d := nearestCenter(centers, p)
+			distances[i] = d
+			total += d
+		}
+		if total == 0 {
+			// fewer distinct colors than k
+			break
+		}
+		x := rnd.Float64() * total
+		i := 0
+		for ; i < len(points)-1; i++ {
+			x -= distances[i]
+			if x < 0 {
+				break
+			}
+		}
+		centers = append(centers, points[i])
+	}
+	return centers
+}
+
+// nearestCenter returns the index of the center closest to p and its squared
+// distance.
+func nearestCenter(centers [][3]float64, p [3]float64) (int, float64) {
+	best := 0
+	bestDistance := math.Inf(1)
+	for j, c := range centers {
+		dl, da, db := p[0]-c[0], p[1]-c[1], p[2]-c[2]
+		d := dl*dl + da*da + db*db
+		if d < bestDistance {
+			best = j
+			bestDistance = d
+		}
+	}
+	return best, bestDistance
+}
+
+// Swatch renders the palette as a row of size by size squares.
+func (palette Palette) Swatch(size int) image.Image {
+	im := image.NewNRGBA(image.Rect(0, 0, size*len(palette), size))
+	for i, c := range palette {
+		rect := image.Rect(i*size, 0, (i+1)*size, size)
+		c.A = 255
+		draw.Draw(im, rect, &image.Uniform{c.NRGBA()}, image.ZP, draw.Src)
+	}
+	return im
+}
+
+// JSON returns the palette as a JSON array of hex colors.
+func (palette Palette) JSON() string {
+	colors := make([]string, len(palette))
+	for i, c := range palette {
+		colors[i] = fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	data, _ := json.MarshalIndent(colors, "", "  ")
+	return string(data) + "\n"
+}
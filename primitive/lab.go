@@ -0,0 +1,310 @@
+package primitive
+
+import (
+	"image"
+	"math"
+	"sync"
+)
+
+// D65 reference white
+const (
+	whiteX = 0.95047
+	whiteY = 1.0
+	whiteZ = 1.08883
+)
+
+const labFTableSize = 4096
+
+var srgbLinear [256]float64
+
+// labFTable samples labF over [0, 1] for linear interpolation
+var labFTable [labFTableSize + 1]float64
+
+func init() {
+	for i := range srgbLinear {
+		srgbLinear[i] = linearize(float64(i) / 255)
+	}
+	for i := range labFTable {
+		labFTable[i] = labF(float64(i) / labFTableSize)
+	}
+}
+
+// linearize converts a gamma encoded sRGB component in [0, 1] to linear light.
+func linearize(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// delinearize converts a linear light component in [0, 1] to gamma encoded sRGB.
+func delinearize(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+func labF(t float64) float64 {
+	if t > 216.0/24389 {
+		return math.Cbrt(t)
+	}
+	return (24389.0/27*t + 16) / 116
+}
+
+func labFInv(t float64) float64 {
+	if t*t*t > 216.0/24389 {
+		return t * t * t
+	}
+	return (116*t - 16) * 27 / 24389
+}
+
+// fastLabF approximates labF with a table lookup for t in [0, 1].
+func fastLabF(t float64) float64 {
+	if t < 0 || t >= 1 {
+		return labF(t)
+	}
+	f := t * labFTableSize
+	i := int(f)
+	p := f - float64(i)
+	return labFTable[i]*(1-p) + labFTable[i+1]*p
+}
+
+// linearToLab converts linear light RGB components in [0, 1] to CIELAB.
+func linearToLab(r, g, b float64) (l, a, bb float64) {
+	x := (0.4124564*r + 0.3575761*g + 0.1804375*b) / whiteX
+	y := (0.2126729*r + 0.7151522*g + 0.0721750*b) / whiteY
+	z := (0.0193339*r + 0.1191920*g + 0.9503041*b) / whiteZ
+	fx, fy, fz := fastLabF(x), fastLabF(y), fastLabF(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// labToLinear converts CIELAB to linear light RGB components, which may fall
+// outside of [0, 1] for colors outside of the sRGB gamut.
+func labToLinear(l, a, b float64) (r, g, bb float64) {
+	fy := (l + 16) / 116
+	fx := fy + a/500
+	fz := fy - b/200
+	x := labFInv(fx) * whiteX
+	y := labFInv(fy) * whiteY
+	z := labFInv(fz) * whiteZ
+	r = 3.2404542*x - 1.5371385*y - 0.4985314*z
+	g = -0.9692660*x + 1.8760108*y + 0.0415560*z
+	bb = 0.0556434*x - 0.2040259*y + 1.0572252*z
+	return
+}
+
+// rgbToLab converts 8-bit sRGB components to CIELAB.
+func rgbToLab(r, g, b uint8) (l, a, bb float64) {
+	return linearToLab(srgbLinear[r], srgbLinear[g], srgbLinear[b])
+}
+
+// labToRGB converts CIELAB to 8-bit sRGB components, clamping out of gamut
+// colors.
+func labToRGB(l, a, b float64) (r, g, bb int) {
+	lr, lg, lb := labToLinear(l, a, b)
+	r = clampInt(int(delinearize(clamp(lr, 0, 1))*255+0.5), 0, 255)
+	g = clampInt(int(delinearize(clamp(lg, 0, 1))*255+0.5), 0, 255)
+	bb = clampInt(int(delinearize(clamp(lb, 0, 1))*255+0.5), 0, 255)
+	return
+}
+
+// ciede2000 returns the CIEDE2000 color difference between two CIELAB colors.
+func ciede2000(l1, a1, b1, l2, a2, b2 float64) float64 {
+	const pow25_7 = 6103515625 // 25^7
+	c1 := math.Hypot(a1, b1)
+	c2 := math.Hypot(a2, b2)
+	cm := (c1 + c2) / 2
+	cm7 := math.Pow(cm, 7)
+	g := 0.5 * (1 - math.Sqrt(cm7/(cm7+pow25_7)))
+	a1p := a1 * (1 + g)
+	a2p := a2 * (1 + g)
+	c1p := math.Hypot(a1p, b1)
+	c2p := math.Hypot(a2p, b2)
+	h1p := hueAngle(b1, a1p)
+	h2p := hueAngle(b2, a2p)
+
+	dl := l2 - l1
+	dc := c2p - c1p
+	var dh float64
+	if c1p*c2p != 0 {
+		dh = h2p - h1p
+		if dh > 180 {
+			dh -= 360
+		} else if dh < -180 {
+			dh += 360
+		}
+	}
+	dH := 2 * math.Sqrt(c1p*c2p) * math.Sin(radians(dh/2))
+
+	lm := (l1 + l2) / 2
+	cmp := (c1p + c2p) / 2
+	hm := h1p + h2p
+	if c1p*c2p != 0 {
+		if math.Abs(h1p-h2p) <= 180 {
+			hm /= 2
+		} else if hm < 360 {
+			hm = (hm + 360) / 2
+		} else {
+			hm = (hm - 360) / 2
+		}
+	}
+	t := 1 - 0.17*math.Cos(radians(hm-30)) + 0.24*math.Cos(radians(2*hm)) +
+		0.32*math.Cos(radians(3*hm+6)) - 0.20*math.Cos(radians(4*hm-63))
+	dtheta := 30 * math.Exp(-math.Pow((hm-275)/25, 2))
+	cmp7 := math.Pow(cmp, 7)
+	rc := 2 * math.Sqrt(cmp7/(cmp7+pow25_7))
+	lm50 := (lm - 50) * (lm - 50)
+	sl := 1 + 0.015*lm50/math.Sqrt(20+lm50)
+	sc := 1 + 0.045*cmp
+	sh := 1 + 0.015*cmp*t
+	rt := -math.Sin(radians(2*dtheta)) * rc
+
+	kl := dl / sl
+	kc := dc / sc
+	kh := dH / sh
+	return math.Sqrt(kl*kl + kc*kc + kh*kh + rt*kc*kh)
+}
+
+func hueAngle(b, a float64) float64 {
+	if a == 0 && b == 0 {
+		return 0
+	}
+	h := degrees(math.Atan2(b, a))
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+// LabMetric measures error as the squared CIELAB color difference, using
+// either the Euclidean CIE76 distance or CIEDE2000. Differences in alpha are
+// counted on the same 0-100 scale as lightness.
+type LabMetric struct {
+	DE2000  bool
+	targets sync.Map // *image.RGBA -> []float32 of L, a, b, alpha per pixel
+}
+
+func NewLabMetric(de2000 bool) *LabMetric {
+	return &LabMetric{DE2000: de2000}
+}
+
+// lab returns the target converted to CIELAB, indexed like target.Pix.
+// Targets do not change during a run, so conversions are cached.
+func (m *LabMetric) lab(target *image.RGBA) []float32 {
+	if v, ok := m.targets.Load(target); ok {
+		return v.([]float32)
+	}
+	buf := make([]float32, len(target.Pix))
+	for i := 0; i+3 < len(target.Pix); i += 4 {
+		l, a, b := rgbToLab(target.Pix[i], target.Pix[i+1], target.Pix[i+2])
+		buf[i] = float32(l)
+		buf[i+1] = float32(a)
+		buf[i+2] = float32(b)
+		buf[i+3] = float32(target.Pix[i+3]) * 100 / 255
+	}
+	v, _ := m.targets.LoadOrStore(target, buf)
+	return v.([]float32)
+}
+
+func (m *LabMetric) lineError(lab []float32, current *image.RGBA, weights *image.Gray, y, x1, x2 int) float64 {
+	var total float64
+	i := current.PixOffset(x1, y)
+	j := -1
+	if weights != nil {
+		j = weights.PixOffset(x1, y)
+	}
+	for x := x1; x <= x2; x++ {
+		var e float64
+		l, a, b := rgbToLab(current.Pix[i], current.Pix[i+1], current.Pix[i+2])
+		da := float64(lab[i+3]) - float64(current.Pix[i+3])*100/255
+		if m.DE2000 {
+			d := ciede2000(float64(lab[i]), float64(lab[i+1]), float64(lab[i+2]), l, a, b)
+			e = d*d + da*da
+		} else {
+			dl := float64(lab[i]) - l
+			dA := float64(lab[i+1]) - a
+			dB := float64(lab[i+2]) - b
+			e = dl*dl + dA*dA + dB*dB + da*da
+		}
+		if j >= 0 {
+			e *= float64(weights.Pix[j]) / 255
+			j++
+		}
+		total += e
+		i += 4
+	}
+	return total
+}
+
+func (m *LabMetric) Total(target, current *image.RGBA, weights *image.Gray) float64 {
+	lab := m.lab(target)
+	size := target.Bounds().Size()
+	var total float64
+	for y := 0; y < size.Y; y++ {
+		total += m.lineError(lab, current, weights, y, 0, size.X-1)
+	}
+	return total
+}
+
+func (m *LabMetric) Delta(target, before, after *image.RGBA, weights *image.Gray, lines []Scanline) float64 {
+	lab := m.lab(target)
+	var total float64
+	for _, line := range lines {
+		total -= m.lineError(lab, before, weights, line.Y, line.X1, line.X2)
+		total += m.lineError(lab, after, weights, line.Y, line.X1, line.X2)
+	}
+	return total
+}
+
+func (m *LabMetric) Score(total, weight float64) float64 {
+	return math.Sqrt(math.Max(total, 0)/weight) / 100
+}
+
+// Color finds, for each pixel, the source color that would reproduce the
+// target exactly and averages those colors in CIELAB.
+func (m *LabMetric) Color(target, current *image.RGBA, weights *image.Gray, lines []Scanline, alpha int) Color {
+	a := float64(alpha) / 255
+	var lsum, asum, bsum, count float64
+	for _, line := range lines {
+		i := target.PixOffset(line.X1, line.Y)
+		j := -1
+		if weights != nil {
+			j = weights.PixOffset(line.X1, line.Y)
+		}
+		for x := line.X1; x <= line.X2; x++ {
+			var s [3]uint8
+			// match the target's unpremultiplied color at the alpha the
+			// result will have
+			k := 1.0
+			if ta := float64(target.Pix[i+3]); ta != 0 {
+				k = (a + float64(current.Pix[i+3])/255*(1-a)) * 255 / ta
+			}
+			for c := 0; c < 3; c++ {
+				t := float64(target.Pix[i+c]) * k
+				d := float64(current.Pix[i+c])
+				s[c] = uint8(clamp((t-d*(1-a))/a+0.5, 0, 255))
+			}
+			l, A, b := rgbToLab(s[0], s[1], s[2])
+			w := 1.0
+			if j >= 0 {
+				w = float64(weights.Pix[j])
+				j++
+			}
+			if target.Pix[i+3] == 0 {
+				// fully transparent pixels have no color to match
+				w = 0
+			}
+			lsum += w * l
+			asum += w * A
+			bsum += w * b
+			count += w
+			i += 4
+		}
+	}
+	if count == 0 {
+		return Color{}
+	}
+	r, g, b := labToRGB(lsum/count, asum/count, bsum/count)
+	return Color{r, g, b, alpha}
+}
@@ -0,0 +1,260 @@
+package primitive
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/fogleman/gg"
+)
+
+// LatticeKind is the shape of the cells of a lattice.
+type LatticeKind int
+
+const (
+	LatticeSquare LatticeKind = iota
+	LatticeHex
+	LatticeTriangle
+	LatticePixel
+)
+
+// Lattice snaps shapes to the cells of a grid of squares, hexagons or
+// triangles. Cells are Cells across the width of the image at the coarsest
+// level and each further level halves their size. A pixel lattice is a
+// single level of squares meant to be filled with opaque colors.
+type Lattice struct {
+	Kind   LatticeKind
+	Cells  int
+	Levels int
+}
+
+// ParseLattice parses a lattice: KIND:CELLS or KIND:CELLS:LEVELS where KIND
+// is square, hex, triangle or pixel.
+func ParseLattice(s string) (*Lattice, error) {
+	split := strings.Split(s, ":")
+	if len(split) < 2 || len(split) > 3 {
+		return nil, fmt.Errorf("invalid lattice %q, expected KIND:CELLS[:LEVELS]", s)
+	}
+	lattice := &Lattice{Levels: 1}
+	switch split[0] {
+	case "square":
+		lattice.Kind = LatticeSquare
+	case "hex":
+		lattice.Kind = LatticeHex
+	case "triangle":
+		lattice.Kind = LatticeTriangle
+	case "pixel":
+		lattice.Kind = LatticePixel
+	default:
+		return nil, fmt.Errorf("unrecognized lattice: %s", split[0])
+	}
+	cells, err := strconv.Atoi(split[1])
+	if err != nil || cells < 1 {
+		return nil, fmt.Errorf("invalid lattice cell count: %s", split[1])
+	}
+	lattice.Cells = cells
+	if len(split) == 3 {
+		levels, err := strconv.Atoi(split[2])
+		if err != nil || levels < 1 {
+			return nil, fmt.Errorf("invalid lattice levels: %s", split[2])
+		}
+		if lattice.Kind == LatticePixel && levels != 1 {
+			return nil, fmt.Errorf("pixel lattices have a single level")
+		}
+		lattice.Levels = levels
+	}
+	return lattice, nil
+}
+
+// size returns the width of a cell at a level in the image of worker.
+func (l *Lattice) size(worker *Worker, level int) float64 {
+	return float64(worker.W) / float64(l.Cells) / float64(int(1)<<uint(level))
+}
+
+// rowHeight returns the distance between rows of cells of the given width.
+func (l *Lattice) rowHeight(s float64) float64 {
+	switch l.Kind {
+	case LatticeHex, LatticeTriangle:
+		// rows of pointy topped hexagons overlap by a quarter of their height
+		// and rows of triangles are as tall as the triangles
+		return s * math.Sqrt(3) / 2
+	}
+	return s
+}
+
+// grid returns the number of columns and rows of cells at a level. Cells
+// on the last row or column may be cut off by the edge of the image.
+func (l *Lattice) grid(worker *Worker, level int) (cols, rows int) {
+	s := l.size(worker, level)
+	cols = int(math.Ceil(float64(worker.W)/s)) + 1
+	if l.Kind == LatticeTriangle {
+		// triangles overlap their neighbors by half their width
+		cols = 2*cols + 1
+	}
+	rows = int(math.Ceil(float64(worker.H)/l.rowHeight(s))) + 1
+	return
+}
+
+// cellAt returns the cell at a level that covers or is nearest to a point.
+func (l *Lattice) cellAt(worker *Worker, level int, x, y float64) (i, j int) {
+	s := l.size(worker, level)
+	cols, rows := l.grid(worker, level)
+	switch l.Kind {
+	case LatticeHex:
+		j = int(math.Round(y / l.rowHeight(s)))
+		i = int(math.Round((x - float64(j%2)*s/2) / s))
+	case LatticeTriangle:
+		// the point lies between the apexes of triangles i and i+1, split
+		// by the edge they share
+		h := l.rowHeight(s)
+		j = int(math.Floor(y / h))
+		u := x / (s / 2)
+		i = int(math.Floor(u))
+		f, v := u-float64(i), y/h-float64(j)
+		if (i+j)%2 != 0 {
+			// triangle i points down
+			v = 1 - v
+		}
+		if f > v {
+			i++
+		}
+	default:
+		i, j = int(x/s), int(y/s)
+	}
+	return clampInt(i, 0, cols-1), clampInt(j, 0, rows-1)
+}
+
+// corners returns the corners of a cell.
+func (l *Lattice) corners(worker *Worker, i, j, level int) (xs, ys []float64) {
+	s := l.size(worker, level)
+	h := l.rowHeight(s)
+	switch l.Kind {
+	case LatticeHex:
+		cx := float64(i)*s + float64(j%2)*s/2
+		cy := float64(j) * h
+		r := s / math.Sqrt(3)
+		for k := 0; k < 6; k++ {
+			a := radians(float64(60*k + 30))
+			xs = append(xs, cx+r*math.Cos(a))
+			ys = append(ys, cy+r*math.Sin(a))
+		}
+	case LatticeTriangle:
+		x0 := float64(i-1) * s / 2
+		y0, y1 := float64(j)*h, float64(j+1)*h
+		if (i+j)%2 == 0 {
+			xs, ys = []float64{x0, x0 + s, x0 + s/2}, []float64{y1, y1, y0}
+		} else {
+			xs, ys = []float64{x0, x0 + s, x0 + s/2}, []float64{y0, y0, y1}
+		}
+	default:
+		x0, y0 := float64(i)*s, float64(j)*s
+		xs, ys = []float64{x0, x0 + s, x0 + s, x0}, []float64{y0, y0, y0 + s, y0 + s}
+	}
+	return
+}
+
+// Tile is a shape that fills one cell of a lattice.
+type Tile struct {
+	Worker  *Worker
+	Lattice *Lattice
+	I, J    int
+	Level   int
+}
+
+// NewRandomTile returns a tile at a random level over a point chosen by the
+// worker's placement strategy.
+func NewRandomTile(worker *Worker) *Tile {
+	l := worker.Lattice
+	level := worker.Rnd.Intn(l.Levels)
+	x, y := worker.RandomPointF()
+	i, j := l.cellAt(worker, level, x, y)
+	return &Tile{worker, l, i, j, level}
+}
+
+// shape returns the rectangle or polygon covering the tile's cell.
+func (t *Tile) shape() Shape {
+	xs, ys := t.Lattice.corners(t.Worker, t.I, t.J, t.Level)
+	if t.Lattice.Kind == LatticeSquare || t.Lattice.Kind == LatticePixel {
+		// round both edges so that neighboring cells share them exactly
+		x1, y1 := int(math.Round(xs[0])), int(math.Round(ys[0]))
+		x2, y2 := int(math.Round(xs[2]))-1, int(math.Round(ys[2]))-1
+		w, h := t.Worker.W, t.Worker.H
+		return &Rectangle{t.Worker, clampInt(x1, 0, w-1), clampInt(y1, 0, h-1), clampInt(x2, 0, w-1), clampInt(y2, 0, h-1)}
+	}
+	return &Polygon{t.Worker, len(xs), true, 0, t.Lattice.size(t.Worker, t.Level), 0, xs, ys}
+}
+
+func (t *Tile) Draw(dc *gg.Context, scale float64) {
+	t.shape().Draw(dc, scale)
+}
+
+func (t *Tile) SVG(attrs string) string {
+	return t.shape().SVG(attrs)
+}
+
+func (t *Tile) Copy() Shape {
+	a := *t
+	return &a
+}
+
+// Mutate moves the tile to a neighboring cell, jumps to a random cell or
+// changes its subdivision level, since cells cannot move continuously.
+func (t *Tile) Mutate() {
+	l := t.Lattice
+	rnd := t.Worker.Rnd
+	moves := 2
+	if l.Levels > 1 {
+		moves = 3
+	}
+	switch rnd.Intn(moves) {
+	case 0:
+		t.I += rnd.Intn(3) - 1
+		t.J += rnd.Intn(3) - 1
+	case 1:
+		cols, rows := l.grid(t.Worker, t.Level)
+		t.I, t.J = rnd.Intn(cols), rnd.Intn(rows)
+	case 2:
+		// keep the center of the tile in the new cell
+		xs, ys := l.corners(t.Worker, t.I, t.J, t.Level)
+		var x, y float64
+		for k := range xs {
+			x += xs[k] / float64(len(xs))
+			y += ys[k] / float64(len(ys))
+		}
+		t.Level = clampInt(t.Level+2*rnd.Intn(2)-1, 0, l.Levels-1)
+		t.I, t.J = l.cellAt(t.Worker, t.Level, x, y)
+	}
+	cols, rows := l.grid(t.Worker, t.Level)
+	t.I, t.J = clampInt(t.I, 0, cols-1), clampInt(t.J, 0, rows-1)
+}
+
+func (t *Tile) Rasterize() []Scanline {
+	return t.shape().Rasterize()
+}
+
+func (t *Tile) Area() float64 {
+	return t.shape().Area()
+}
+
+// Rescale keeps the cell, since cells are laid out relative to the size of
+// the worker's image.
+func (t *Tile) Rescale(worker *Worker, factor float64) Shape {
+	a := *t
+	a.Worker = worker
+	return &a
+}
+
+func (t *Tile) Transform(tr Transform) Shape {
+	return t.shape().Transform(tr)
+}
+
+// SetLattice snaps the shapes of the tile shape type to a lattice.
+func (model *Model) SetLattice(lattice *Lattice) {
+	model.Lattice = lattice
+	for _, worker := range model.Workers {
+		for w := worker; w != nil; w = w.Coarse {
+			w.Lattice = lattice
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package primitive
+
+import "testing"
+
+func TestParseLattice(t *testing.T) {
+	tests := []struct {
+		s    string
+		want *Lattice
+	}{
+		{"square:10", &Lattice{LatticeSquare, 10, 1}},
+		{"hex:8:3", &Lattice{LatticeHex, 8, 3}},
+		{"triangle:5:2", &Lattice{LatticeTriangle, 5, 2}},
+		{"pixel:32", &Lattice{LatticePixel, 32, 1}},
+		{"pixel:32:1", &Lattice{LatticePixel, 32, 1}},
+		{"square", nil},
+		{"square:10:2:1", nil},
+		{"circle:10", nil},
+		{"hex:0", nil},
+		{"hex:x", nil},
+		{"hex:4:0", nil},
+		{"pixel:32:2", nil},
+	}
+	for _, test := range tests {
+		got, err := ParseLattice(test.s)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("ParseLattice(%q) = %v, want an error", test.s, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseLattice(%q): %v", test.s, err)
+		} else if *got != *test.want {
+			t.Errorf("ParseLattice(%q) = %v, want %v", test.s, got, test.want)
+		}
+	}
+}
+
+func TestLatticeCellAt(t *testing.T) {
+	worker := &Worker{W: 101, H: 67}
+	for _, kind := range []LatticeKind{LatticeSquare, LatticeHex, LatticeTriangle, LatticePixel} {
+		l := &Lattice{kind, 7, 3}
+		for level := 0; level < l.Levels; level++ {
+			cols, rows := l.grid(worker, level)
+			for j := 0; j < rows; j++ {
+				for i := 0; i < cols; i++ {
+					// the centroid of a cell lies inside it
+					xs, ys := l.corners(worker, i, j, level)
+					var x, y float64
+					for k := range xs {
+						x += xs[k] / float64(len(xs))
+						y += ys[k] / float64(len(ys))
+					}
+					if gi, gj := l.cellAt(worker, level, x, y); gi != i || gj != j {
+						t.Fatalf("kind %d level %d: cellAt(%g, %g) = %d, %d, want %d, %d",
+							kind, level, x, y, gi, gj, i, j)
+					}
+				}
+			}
+		}
+	}
+}
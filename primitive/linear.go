@@ -0,0 +1,52 @@
+package primitive
+
+// In linear mode the target and current images stay 8-bit sRGB, and each
+// blend converts the pixels it touches to linear light and back. Rounding
+// back to 8 bits after every shape loses precision in dark tones, so the
+// search only approximates linear light there. The output canvas keeps
+// float linear light and is converted once when saved.
+
+// linearSRGB maps linear light in [0, 65535] to 8-bit sRGB.
+var linearSRGB [65536]uint8
+
+func init() {
+	for i := range linearSRGB {
+		linearSRGB[i] = uint8(delinearize(float64(i)/65535)*255 + 0.5)
+	}
+}
+
+// encodeLinear converts linear light in [0, 1] to 8-bit sRGB.
+func encodeLinear(v float64) uint8 {
+	return linearSRGB[int(clamp(v, 0, 1)*65535+0.5)]
+}
+
+// pixelLinear returns the premultiplied linear light color and the alpha of
+// the premultiplied sRGB pixel at the start of p.
+func pixelLinear(p []uint8) (r, g, b, a float64) {
+	switch p[3] {
+	case 0:
+		return 0, 0, 0, 0
+	case 255:
+		return srgbLinear[p[0]], srgbLinear[p[1]], srgbLinear[p[2]], 1
+	}
+	pa := int(p[3])
+	a = float64(pa) / 255
+	r = srgbLinear[(int(p[0])*255+pa/2)/pa] * a
+	g = srgbLinear[(int(p[1])*255+pa/2)/pa] * a
+	b = srgbLinear[(int(p[2])*255+pa/2)/pa] * a
+	return
+}
+
+// setPixelLinear stores a premultiplied linear light color as a premultiplied
+// sRGB pixel at the start of p.
+func setPixelLinear(p []uint8, r, g, b, a float64) {
+	if a <= 0 {
+		p[0], p[1], p[2], p[3] = 0, 0, 0, 0
+		return
+	}
+	pa := int(clamp(a, 0, 1)*255 + 0.5)
+	p[0] = uint8((int(encodeLinear(r/a))*pa + 127) / 255)
+	p[1] = uint8((int(encodeLinear(g/a))*pa + 127) / 255)
+	p[2] = uint8((int(encodeLinear(b/a))*pa + 127) / 255)
+	p[3] = uint8(pa)
+}
@@ -0,0 +1,367 @@
+package primitive
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"time"
+
+	"github.com/fogleman/gg"
+)
+
+const (
+	// corners of the canvas, which are always part of the mesh
+	lowPolyFixed = 4
+	// candidate meshes evaluated per step, split among the workers
+	lowPolyTrials = 64
+)
+
+// LowPoly approximates the target with a mesh instead of overlapping
+// shapes. A set of points is triangulated, and the Delaunay triangles, or
+// the Voronoi cells around the points, tile the whole image with each one
+// filled with its mean target color. Every step adds, moves or removes a
+// point, keeping whichever candidate lowers the error the most. The mesh
+// replaces the shapes of the model after each step.
+type LowPoly struct {
+	Model   *Model
+	Voronoi bool
+	X, Y    []float64
+	// the image before the mesh, which every mesh is drawn over
+	background *image.RGBA
+	// per worker images to try palette colors in
+	scratch map[*Worker]*image.RGBA
+}
+
+type lowPolyCandidate struct {
+	X, Y  []float64
+	Total float64
+}
+
+// NewLowPoly starts a mesh over the corners of the model's image.
+func NewLowPoly(model *Model, voronoi bool) *LowPoly {
+	w := float64(model.Target.Bounds().Dx())
+	h := float64(model.Target.Bounds().Dy())
+	p := &LowPoly{Model: model, Voronoi: voronoi}
+	p.background = imageToRGBA(model.Current)
+	p.scratch = make(map[*Worker]*image.RGBA)
+	for _, worker := range model.Workers {
+		p.scratch[worker] = image.NewRGBA(model.Target.Bounds())
+	}
+	// the corners lie just outside the image so the mesh covers every pixel
+	p.X = []float64{-1, w, w, -1}
+	p.Y = []float64{-1, -1, h, h}
+	p.apply(&lowPolyCandidate{p.X, p.Y, 0})
+	return p
+}
+
+// Step tries adding, moving and removing points and keeps the best change
+// if it lowers the error. It returns the number of meshes evaluated. Each
+// worker stops early at the time limit but always tries one mesh.
+func (p *LowPoly) Step() int {
+	model := p.Model
+	wn := len(model.Workers)
+	ch := make(chan *lowPolyCandidate, wn)
+	counts := make(chan int, wn)
+	m := (lowPolyTrials + wn - 1) / wn
+	deadline := model.deadline()
+	for _, worker := range model.Workers {
+		worker.Tiles = model.Tiles
+		worker.Deadline = deadline
+		go func(worker *Worker) {
+			var best *lowPolyCandidate
+			i := 0
+			for ; i < m; i++ {
+				if i > 0 && !worker.Deadline.IsZero() && time.Now().After(worker.Deadline) {
+					break
+				}
+				c := p.candidate(worker)
+				_, c.Total = p.render(worker, c.X, c.Y)
+				if best == nil || c.Total < best.Total {
+					best = c
+				}
+			}
+			counts <- i
+			ch <- best
+		}(worker)
+	}
+	var best *lowPolyCandidate
+	var n int
+	for i := 0; i < wn; i++ {
+		n += <-counts
+		c := <-ch
+		if best == nil || c.Total < best.Total {
+			best = c
+		}
+	}
+	if best.Total < model.Total {
+		p.apply(best)
+	}
+	return n
+}
+
+// candidate returns the points with one point added, moved or removed.
+func (p *LowPoly) candidate(worker *Worker) *lowPolyCandidate {
+	rnd := worker.Rnd
+	n := len(p.X)
+	xs := append([]float64(nil), p.X...)
+	ys := append([]float64(nil), p.Y...)
+	w, h := float64(worker.W), float64(worker.H)
+	r := rnd.Float64()
+	switch {
+	case n == lowPolyFixed || r < 0.5:
+		x, y := worker.RandomPointF()
+		xs = append(xs, x)
+		ys = append(ys, y)
+	case r < 0.8:
+		// move about a quarter of the typical spacing between points
+		i := lowPolyFixed + rnd.Intn(n-lowPolyFixed)
+		d := worker.mutation(math.Sqrt(w*h/float64(n)) / 4)
+		xs[i] = clamp(xs[i]+rnd.NormFloat64()*d, 0, w-1)
+		ys[i] = clamp(ys[i]+rnd.NormFloat64()*d, 0, h-1)
+	default:
+		i := lowPolyFixed + rnd.Intn(n-lowPolyFixed)
+		xs = append(xs[:i], xs[i+1:]...)
+		ys = append(ys[:i], ys[i+1:]...)
+	}
+	return &lowPolyCandidate{X: xs, Y: ys}
+}
+
+// polygons returns the triangles or Voronoi cells of the mesh.
+func (p *LowPoly) polygons(worker *Worker, xs, ys []float64) []*Polygon {
+	triangles := delaunay(xs, ys)
+	var result []*Polygon
+	if !p.Voronoi {
+		for _, t := range triangles {
+			px := []float64{xs[t[0]], xs[t[1]], xs[t[2]]}
+			py := []float64{ys[t[0]], ys[t[1]], ys[t[2]]}
+			result = append(result, &Polygon{worker, 3, false, 0, 0, 0, px, py})
+		}
+		return result
+	}
+	neighbors := make([]map[int]bool, len(xs))
+	for i := range neighbors {
+		neighbors[i] = make(map[int]bool)
+	}
+	for _, t := range triangles {
+		for k := 0; k < 3; k++ {
+			a, b := t[k], t[(k+1)%3]
+			neighbors[a][b] = true
+			neighbors[b][a] = true
+		}
+	}
+	x0, y0 := xs[0], ys[0]
+	x1, y1 := xs[2], ys[2]
+	for i := range xs {
+		// the cell is the part of the image closer to the point than to
+		// any of its Delaunay neighbors
+		px := []float64{x0, x1, x1, x0}
+		py := []float64{y0, y0, y1, y1}
+		for j := range neighbors[i] {
+			px, py = clipHalfPlane(px, py, xs[i], ys[i], xs[j], ys[j])
+		}
+		if len(px) >= 3 {
+			result = append(result, &Polygon{worker, len(px), false, 0, 0, 0, px, py})
+		}
+	}
+	return result
+}
+
+// render draws the mesh of the given points into the worker's buffer and
+// returns its cells and the resulting error total. Cells are drawn with the
+// model's blend mode and only inside its region, and cells that lie wholly
+// outside the region are left out.
+func (p *LowPoly) render(worker *Worker, xs, ys []float64) ([]*Cell, float64) {
+	model := p.Model
+	copy(worker.Buffer.Pix, p.background.Pix)
+	var cells []*Cell
+	for _, polygon := range p.polygons(worker, xs, ys) {
+		lines := clipLines(model.Region, polygon.Rasterize())
+		if len(lines) == 0 {
+			continue
+		}
+		// cover edge pixels fully so that neighboring cells leave no gaps
+		for i := range lines {
+			lines[i].Alpha = 0xffff
+		}
+		color := blendColor(model.Metric, model.Blend, model.Linear, model.Target, worker.Buffer, model.Weights, lines, 255)
+		if model.Tone != nil {
+			color = model.Tone.project(color)
+		}
+		if len(model.Palette) > 0 {
+			scratch := p.scratch[worker]
+			color, _ = pickPaletteColor(model.Palette, color, func(c Color) float64 {
+				copyLines(scratch, worker.Buffer, lines)
+				blendLines(scratch, c, lines, model.Blend, model.Linear)
+				return model.Metric.Delta(model.Target, worker.Buffer, scratch, model.Weights, lines)
+			})
+		}
+		blendLines(worker.Buffer, color, lines, model.Blend, model.Linear)
+		cells = append(cells, &Cell{polygon, color, 1 / model.Scale})
+	}
+	return cells, model.Metric.Total(model.Target, worker.Buffer, model.Weights)
+}
+
+// apply makes the candidate the current mesh and replaces the shapes of the
+// model with its cells.
+func (p *LowPoly) apply(c *lowPolyCandidate) {
+	model := p.Model
+	worker := model.Workers[0]
+	cells, total := p.render(worker, c.X, c.Y)
+	p.X, p.Y = c.X, c.Y
+	copy(model.Current.Pix, worker.Buffer.Pix)
+	model.Total = total
+	model.Score = model.Metric.Score(total, model.Weight)
+	model.Tiles = NewTiles(model.Target, model.Current, model.Weights)
+	model.Shapes = model.Shapes[:0]
+	model.Colors = model.Colors[:0]
+	model.Blends = model.Blends[:0]
+	model.Layers = model.Layers[:0]
+	model.Scores = model.Scores[:0]
+	model.Context = model.newContext()
+	if model.canvas != nil {
+		model.canvas = model.newCanvas()
+	}
+	for _, cell := range cells {
+		model.Shapes = append(model.Shapes, cell)
+		model.Colors = append(model.Colors, cell.Color)
+		model.Blends = append(model.Blends, model.Blend)
+		model.Layers = append(model.Layers, model.layer(cell.Color))
+		model.Scores = append(model.Scores, model.Score)
+		model.Context.SetRGBA255(cell.Color.R, cell.Color.G, cell.Color.B, cell.Color.A)
+		cell.Draw(model.Context, model.Scale)
+		if model.canvas != nil {
+			model.canvas.Draw(cell, cell.Color, model.Blend, model.Scale)
+		}
+	}
+	vv("LowPoly: points=%d, cells=%d, score=%f\n", len(p.X), len(cells), model.Score)
+}
+
+// Cell is a triangle or Voronoi cell of a low poly mesh. Its outline is
+// stroked in its own color, one output pixel wide, so that neighboring
+// cells meet without antialiasing seams.
+type Cell struct {
+	*Polygon
+	Color  Color
+	Stroke float64
+}
+
+func (c *Cell) Draw(dc *gg.Context, scale float64) {
+	c.Polygon.Draw(dc, scale)
+	dc.NewSubPath()
+	for i := 0; i < c.Order; i++ {
+		dc.LineTo(c.X[i], c.Y[i])
+	}
+	dc.ClosePath()
+	dc.SetLineWidth(c.Stroke * scale)
+	dc.Stroke()
+}
+
+func (c *Cell) SVG(attrs string) string {
+	attrs += fmt.Sprintf(" stroke=\"#%02x%02x%02x\" stroke-width=\"%f\" stroke-linejoin=\"round\"", c.Color.R, c.Color.G, c.Color.B, c.Stroke)
+	return c.Polygon.SVG(attrs)
+}
+
+func (c *Cell) Copy() Shape {
+	return &Cell{c.Polygon.Copy().(*Polygon), c.Color, c.Stroke}
+}
+
+// delaunay triangulates points with the Bowyer-Watson algorithm and returns
+// the triangles as indexes into the points.
+func delaunay(xs, ys []float64) [][3]int {
+	n := len(xs)
+	minX, minY, maxX, maxY := xs[0], ys[0], xs[0], ys[0]
+	for i := range xs {
+		minX, maxX = math.Min(minX, xs[i]), math.Max(maxX, xs[i])
+		minY, maxY = math.Min(minY, ys[i]), math.Max(maxY, ys[i])
+	}
+	// start from a triangle far enough out to contain every point
+	d := math.Max(maxX-minX, maxY-minY) + 1
+	cx, cy := (minX+maxX)/2, (minY+maxY)/2
+	px := append(append([]float64(nil), xs...), cx-20*d, cx, cx+20*d)
+	py := append(append([]float64(nil), ys...), cy-d, cy+20*d, cy-d)
+	triangles := []delaunayTriangle{newDelaunayTriangle(px, py, n, n+1, n+2)}
+	for i := 0; i < n; i++ {
+		// remove the triangles whose circumcircle holds the point and join
+		// the edges of the hole they leave to it
+		var edges [][2]int
+		keep := triangles[:0]
+		for _, t := range triangles {
+			dx, dy := px[i]-t.x, py[i]-t.y
+			if dx*dx+dy*dy < t.r2 {
+				edges = append(edges, [2]int{t.v[0], t.v[1]}, [2]int{t.v[1], t.v[2]}, [2]int{t.v[2], t.v[0]})
+			} else {
+				keep = append(keep, t)
+			}
+		}
+		triangles = keep
+		for j, e := range edges {
+			shared := false
+			for k, f := range edges {
+				if j != k && e[0] == f[1] && e[1] == f[0] {
+					shared = true
+					break
+				}
+			}
+			if !shared {
+				triangles = append(triangles, newDelaunayTriangle(px, py, e[0], e[1], i))
+			}
+		}
+	}
+	var result [][3]int
+	for _, t := range triangles {
+		if t.v[0] < n && t.v[1] < n && t.v[2] < n {
+			result = append(result, t.v)
+		}
+	}
+	return result
+}
+
+type delaunayTriangle struct {
+	v        [3]int
+	x, y, r2 float64
+}
+
+// newDelaunayTriangle returns the triangle with its circumcircle. The
+// circumcircle of a degenerate triangle holds every point, so that the
+// triangle is replaced as soon as possible.
+func newDelaunayTriangle(xs, ys []float64, a, b, c int) delaunayTriangle {
+	t := delaunayTriangle{v: [3]int{a, b, c}}
+	ax, ay := xs[a], ys[a]
+	bx, by := xs[b]-ax, ys[b]-ay
+	cx, cy := xs[c]-ax, ys[c]-ay
+	d := 2 * (bx*cy - by*cx)
+	if d == 0 {
+		t.r2 = math.Inf(1)
+		return t
+	}
+	b2, c2 := bx*bx+by*by, cx*cx+cy*cy
+	ux := (cy*b2 - by*c2) / d
+	uy := (bx*c2 - cx*b2) / d
+	t.x, t.y, t.r2 = ax+ux, ay+uy, ux*ux+uy*uy
+	return t
+}
+
+// clipHalfPlane clips a convex polygon to the points closer to x1, y1 than
+// to x2, y2.
+func clipHalfPlane(xs, ys []float64, x1, y1, x2, y2 float64) ([]float64, []float64) {
+	nx, ny := x2-x1, y2-y1
+	mx, my := (x1+x2)/2, (y1+y2)/2
+	side := func(i int) float64 {
+		return (xs[i]-mx)*nx + (ys[i]-my)*ny
+	}
+	var rx, ry []float64
+	for i := range xs {
+		j := (i + 1) % len(xs)
+		si, sj := side(i), side(j)
+		if si <= 0 {
+			rx = append(rx, xs[i])
+			ry = append(ry, ys[i])
+		}
+		if si*sj < 0 {
+			t := si / (si - sj)
+			rx = append(rx, xs[i]+t*(xs[j]-xs[i]))
+			ry = append(ry, ys[i]+t*(ys[j]-ys[i]))
+		}
+	}
+	return rx, ry
+}
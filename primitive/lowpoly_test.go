@@ -0,0 +1,115 @@
+package primitive
+
+import (
+	"image"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// randomMesh returns the corners of a w by h rectangle followed by n random
+// points inside it.
+func randomMesh(rnd *rand.Rand, w, h float64, n int) (xs, ys []float64) {
+	xs = []float64{0, w, w, 0}
+	ys = []float64{0, 0, h, h}
+	for i := 0; i < n; i++ {
+		xs = append(xs, 1+rnd.Float64()*(w-2))
+		ys = append(ys, 1+rnd.Float64()*(h-2))
+	}
+	return
+}
+
+// polygonArea returns the unsigned area of a polygon.
+func polygonArea(xs, ys []float64) float64 {
+	var a float64
+	for i := range xs {
+		j := (i + 1) % len(xs)
+		a += xs[i]*ys[j] - xs[j]*ys[i]
+	}
+	return math.Abs(a) / 2
+}
+
+func TestDelaunay(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	w, h := 120.0, 80.0
+	for _, n := range []int{0, 1, 5, 50} {
+		xs, ys := randomMesh(rnd, w, h, n)
+		triangles := delaunay(xs, ys)
+		// a triangulation of points with a hull of 4 has 2n-6 triangles
+		if want := 2*len(xs) - 6; len(triangles) != want {
+			t.Errorf("%d points: %d triangles, want %d", len(xs), len(triangles), want)
+		}
+		var area float64
+		for _, tri := range triangles {
+			px := []float64{xs[tri[0]], xs[tri[1]], xs[tri[2]]}
+			py := []float64{ys[tri[0]], ys[tri[1]], ys[tri[2]]}
+			area += polygonArea(px, py)
+			// no point lies inside the circumcircle of a triangle
+			c := newDelaunayTriangle(xs, ys, tri[0], tri[1], tri[2])
+			for i := range xs {
+				dx, dy := xs[i]-c.x, ys[i]-c.y
+				if dx*dx+dy*dy < c.r2*(1-1e-9) {
+					t.Errorf("%d points: point %d inside the circumcircle of %v", len(xs), i, tri)
+				}
+			}
+		}
+		if math.Abs(area-w*h) > 1e-6 {
+			t.Errorf("%d points: triangles cover %g, want %g", len(xs), area, w*h)
+		}
+	}
+}
+
+func TestVoronoiCells(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	w, h := 120.0, 80.0
+	xs, ys := randomMesh(rnd, w, h, 40)
+	p := &LowPoly{Voronoi: true}
+	cells := p.polygons(nil, xs, ys)
+	if len(cells) != len(xs) {
+		t.Errorf("%d cells, want %d", len(cells), len(xs))
+	}
+	var area float64
+	for _, cell := range cells {
+		area += polygonArea(cell.X, cell.Y)
+	}
+	if math.Abs(area-w*h) > 1e-6 {
+		t.Errorf("cells cover %g, want %g", area, w*h)
+	}
+}
+
+func TestLowPolyRegion(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	w, h := 40, 30
+	target := randomRGBA(rnd, w, h)
+	model := NewModel(target, Color{}, w, 1, 0, 0, 0, 1)
+	// the mesh may only cover the left half
+	region := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w/2; x++ {
+			region.Pix[region.PixOffset(x, y)] = 255
+		}
+	}
+	if err := model.SetRegion(region); err != nil {
+		t.Fatal(err)
+	}
+	model.SetBlend(BlendMultiply)
+	p := NewLowPoly(model, false)
+	for i := 0; i < 5; i++ {
+		p.Step()
+	}
+	for y := 0; y < h; y++ {
+		for x := w / 2; x < w; x++ {
+			i := model.Current.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				if model.Current.Pix[i+c] != target.Pix[i+c] {
+					t.Fatalf("mesh drew at %d, %d outside the region", x, y)
+				}
+			}
+		}
+	}
+	for i, blend := range model.Blends {
+		if blend != BlendMultiply {
+			t.Errorf("cell %d blended with %v, want multiply", i, blend)
+		}
+	}
+}
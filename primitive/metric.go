@@ -0,0 +1,69 @@
+package primitive
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Metric measures the error between a target image and the current image.
+// Each pixel's error is scaled by its weight, see SetWeights.
+type Metric interface {
+	// Total returns the error over the whole image.
+	Total(target, current *image.RGBA, weights *image.Gray) float64
+	// Delta returns the change in error when the pixels under lines change
+	// from before to after. before must hold the whole image, while after
+	// is only read under lines.
+	Delta(target, before, after *image.RGBA, weights *image.Gray, lines []Scanline) float64
+	// Score normalizes a total error over an image whose pixel weights add
+	// up to weight, so that scores are comparable across image sizes.
+	Score(total, weight float64) float64
+	// Color returns the color that best matches target on lines when drawn
+	// over current with the given alpha.
+	Color(target, current *image.RGBA, weights *image.Gray, lines []Scanline, alpha int) Color
+}
+
+// ParseMetric returns the metric with the given name: rgb, lab, de2000 or
+// ssim.
+func ParseMetric(name string) (Metric, error) {
+	switch name {
+	case "", "rgb":
+		return &RGBMetric{}, nil
+	case "lab":
+		return NewLabMetric(false), nil
+	case "de2000":
+		return NewLabMetric(true), nil
+	case "ssim":
+		return NewSSIMMetric(), nil
+	}
+	return nil, fmt.Errorf("unrecognized metric: %s", name)
+}
+
+// RGBMetric is the root mean square difference of the raw RGBA bytes.
+type RGBMetric struct{}
+
+func (m *RGBMetric) Total(target, current *image.RGBA, weights *image.Gray) float64 {
+	size := target.Bounds().Size()
+	var total uint64
+	for y := 0; y < size.Y; y++ {
+		total += lineError(target, current, weights, y, 0, size.X-1)
+	}
+	return float64(total) / 255
+}
+
+func (m *RGBMetric) Delta(target, before, after *image.RGBA, weights *image.Gray, lines []Scanline) float64 {
+	var removed, added uint64
+	for _, line := range lines {
+		removed += lineError(target, before, weights, line.Y, line.X1, line.X2)
+		added += lineError(target, after, weights, line.Y, line.X1, line.X2)
+	}
+	return (float64(added) - float64(removed)) / 255
+}
+
+func (m *RGBMetric) Score(total, weight float64) float64 {
+	return math.Sqrt(math.Max(total, 0)/(weight*4)) / 255
+}
+
+func (m *RGBMetric) Color(target, current *image.RGBA, weights *image.Gray, lines []Scanline, alpha int) Color {
+	return computeColor(target, current, weights, lines, alpha)
+}
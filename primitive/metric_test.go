@@ -0,0 +1,55 @@
+package primitive
+
+import (
+	"image"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestMetricDelta(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	w, h := 40, 30
+	target := randomRGBA(rnd, w, h)
+	for _, name := range []string{"rgb", "lab", "de2000", "ssim"} {
+		for _, weights := range []*image.Gray{nil, randomGray(rnd, w, h)} {
+			metric, err := ParseMetric(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := randomRGBA(rnd, w, h)
+			total := metric.Total(target, before, weights)
+			for i := 0; i < 5; i++ {
+				lines := randomLines(rnd, w, h)
+				after := redraw(rnd, before, lines)
+				total += metric.Delta(target, before, after, weights, lines)
+				before = after
+			}
+			want := metric.Total(target, before, weights)
+			if math.Abs(total-want) > 1e-6*math.Abs(want)+1e-6 {
+				t.Errorf("%s: total after deltas %f, want %f", name, total, want)
+			}
+		}
+	}
+}
+
+func TestMetricScore(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	w, h := 40, 30
+	target := randomRGBA(rnd, w, h)
+	for _, name := range []string{"rgb", "lab", "de2000", "ssim"} {
+		metric, err := ParseMetric(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		weight := float64(w * h)
+		same := metric.Score(metric.Total(target, target, nil), weight)
+		if same > 1e-6 {
+			t.Errorf("%s: score of the target itself is %f, want 0", name, same)
+		}
+		other := metric.Score(metric.Total(target, randomRGBA(rnd, w, h), nil), weight)
+		if other <= same {
+			t.Errorf("%s: score of a random image %f is not worse than %f", name, other, same)
+		}
+	}
+}
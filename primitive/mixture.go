@@ -0,0 +1,226 @@
+package primitive
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MixtureEntry is one shape type of a mixture ("Blue Dot Sessions" mode)
+// with its share of the random shapes and its own parameters. Parameters
+// left at their zero values fall back to those of the run and the defaults
+// of the shape type.
+type MixtureEntry struct {
+	Type   ShapeType
+	Weight float64
+	// alpha of the shapes, or 0 for the run's alpha
+	Alpha int
+	// range of the size of new polygons and diamonds in pixels
+	SizeMin, SizeMax float64
+	// number of points, whether they must be convex, and the smallest
+	// interior angle in degrees of polygons and diamonds
+	Order    int
+	Convex   bool
+	MinAngle float64
+	// range of the area of shapes as fractions of the image, applied on top
+	// of the run's area thresholds
+	LowerArea, UpperArea float64
+}
+
+// Mixture is a list of shape types to draw new random shapes from.
+type Mixture []MixtureEntry
+
+// MixturePolicy selects how often the shape type of a mixture is drawn.
+type MixturePolicy int
+
+const (
+	// MixturePerCandidate draws a type for every random shape, so that the
+	// shapes of a step compete across types.
+	MixturePerCandidate MixturePolicy = iota
+	// MixturePerWorker draws a type for each worker in each step.
+	MixturePerWorker
+	// MixturePerStep draws one type for every worker in each step.
+	MixturePerStep
+)
+
+// ParseMixturePolicy parses a mixture policy: candidate, worker or step.
+func ParseMixturePolicy(s string) (MixturePolicy, error) {
+	switch s {
+	case "candidate":
+		return MixturePerCandidate, nil
+	case "worker":
+		return MixturePerWorker, nil
+	case "step":
+		return MixturePerStep, nil
+	}
+	return 0, fmt.Errorf("unrecognized mixture policy: %s", s)
+}
+
+// SetMixturePolicy sets how often the shape type of a mixture is drawn.
+func (model *Model) SetMixturePolicy(policy MixturePolicy) {
+	for _, worker := range model.Workers {
+		for w := worker; w != nil; w = w.Coarse {
+			w.MixturePolicy = policy
+		}
+	}
+}
+
+// ParseMixture parses the entries of a mixture separated by commas. Each
+// entry is MODE:WEIGHT followed by any of alpha=A, size=MIN..MAX,
+// order=N, convex=BOOL, angle=DEGREES and area=LOWER..UPPER separated by
+// colons. A single size is fixed and a single area value is the maximum.
+// Weights are normalized to sum to one.
+func ParseMixture(s string) (Mixture, error) {
+	var mixture Mixture
+	var sum float64
+	for _, field := range strings.Split(s, ",") {
+		entry, err := parseMixtureEntry(field)
+		if err != nil {
+			return nil, err
+		}
+		mixture = append(mixture, entry)
+		sum += entry.Weight
+	}
+	for i := range mixture {
+		mixture[i].Weight /= sum
+	}
+	return mixture, nil
+}
+
+func parseMixtureEntry(s string) (MixtureEntry, error) {
+	split := strings.Split(s, ":")
+	if len(split) < 2 {
+		return MixtureEntry{}, fmt.Errorf("invalid mixture entry %q, expected MODE:WEIGHT[:KEY=VALUE...]", s)
+	}
+	mode, err := strconv.Atoi(split[0])
+	if err != nil {
+		return MixtureEntry{}, fmt.Errorf("invalid mixture mode in %q", s)
+	}
+	entry := MixtureEntry{Type: ShapeType(mode)}
+	switch entry.Type {
+	case ShapeTypeBlueDotSessions:
+		return entry, fmt.Errorf("cannot use a mixture inside a mixture: %q", s)
+	case ShapeTypePolygon:
+		entry.SizeMin, entry.SizeMax, entry.Order, entry.Convex, entry.MinAngle = 40, 40, 4, true, 15
+	case ShapeTypeDiamond:
+		entry.SizeMin, entry.SizeMax, entry.Order, entry.Convex, entry.MinAngle = 20, 20, 4, true, 15
+	default:
+		if entry.Type < ShapeTypeAny || entry.Type > ShapeTypeTile {
+			return entry, fmt.Errorf("unrecognized mixture mode in %q", s)
+		}
+	}
+	entry.Weight, err = strconv.ParseFloat(split[1], 64)
+	if err != nil || entry.Weight <= 0 {
+		return entry, fmt.Errorf("invalid mixture weight in %q", s)
+	}
+	polygonal := entry.Type == ShapeTypePolygon || entry.Type == ShapeTypeDiamond
+	for _, param := range split[2:] {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			return entry, fmt.Errorf("invalid mixture parameter %q, expected KEY=VALUE", param)
+		}
+		key, value := kv[0], kv[1]
+		if !polygonal && (key == "size" || key == "order" || key == "convex" || key == "angle") {
+			return entry, fmt.Errorf("%s only applies to polygons and diamonds: %q", key, s)
+		}
+		switch key {
+		case "alpha":
+			entry.Alpha, err = strconv.Atoi(value)
+			if err == nil && (entry.Alpha < 0 || entry.Alpha > 255) {
+				err = fmt.Errorf("alpha must be between 0 and 255")
+			}
+		case "size":
+			entry.SizeMin, entry.SizeMax, err = parseMixtureRange(value)
+			if err == nil && entry.SizeMin == 0 {
+				entry.SizeMin = entry.SizeMax
+			}
+			if err == nil && entry.SizeMax < 1 {
+				err = fmt.Errorf("size must be at least 1")
+			}
+		case "order":
+			entry.Order, err = strconv.Atoi(value)
+			if err == nil && entry.Order < 3 {
+				err = fmt.Errorf("order must be at least 3")
+			}
+			if err == nil && entry.Type == ShapeTypeDiamond && entry.Order != 4 {
+				err = fmt.Errorf("diamonds have 4 points")
+			}
+		case "convex":
+			entry.Convex, err = strconv.ParseBool(value)
+		case "angle":
+			entry.MinAngle, err = strconv.ParseFloat(value, 64)
+			if err == nil && (entry.MinAngle < 0 || entry.MinAngle >= 180) {
+				err = fmt.Errorf("angle must be between 0 and 180")
+			}
+		case "area":
+			entry.LowerArea, entry.UpperArea, err = parseMixtureRange(value)
+			if err == nil && entry.UpperArea > 1 {
+				err = fmt.Errorf("area fractions must be between 0 and 1")
+			}
+		default:
+			err = fmt.Errorf("unrecognized parameter")
+		}
+		if err != nil {
+			return entry, fmt.Errorf("invalid mixture parameter %q: %v", param, err)
+		}
+	}
+	// the interior angles of a convex polygon average (n-2)*180/n degrees,
+	// so no shape could satisfy a larger minimum
+	if polygonal && entry.Convex && entry.MinAngle >= float64(entry.Order-2)*180/float64(entry.Order) {
+		return entry, fmt.Errorf("no convex polygon of order %d has every angle above %g: %q", entry.Order, entry.MinAngle, s)
+	}
+	return entry, nil
+}
+
+// parseMixtureRange parses MIN..MAX, or a single maximum with a minimum of
+// zero.
+func parseMixtureRange(s string) (lo, hi float64, err error) {
+	split := strings.Split(s, "..")
+	if len(split) > 2 {
+		return 0, 0, fmt.Errorf("expected MIN..MAX")
+	}
+	hi, err = strconv.ParseFloat(split[len(split)-1], 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(split) == 2 {
+		lo, err = strconv.ParseFloat(split[0], 64)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if lo < 0 || hi < lo {
+		return 0, 0, fmt.Errorf("invalid range %s", s)
+	}
+	return lo, hi, nil
+}
+
+// randomShape returns a new random shape of the entry's type.
+func (entry *MixtureEntry) randomShape(worker *Worker) Shape {
+	switch entry.Type {
+	case ShapeTypePolygon, ShapeTypeDiamond:
+		size := entry.SizeMax
+		if entry.SizeMin < entry.SizeMax {
+			size = entry.SizeMin + worker.Rnd.Float64()*(entry.SizeMax-entry.SizeMin)
+		}
+		if entry.Type == ShapeTypeDiamond {
+			return NewRandomDiamond(worker, entry.Order, entry.Convex, entry.MinAngle, size, 0)
+		}
+		return NewRandomPolygon(worker, entry.Order, entry.Convex, entry.MinAngle, size, 0)
+	}
+	return worker.SimpleRandomShape(entry.Type)
+}
+
+// allows reports whether the area of shape is within the entry's range. A
+// nil entry allows every shape.
+func (entry *MixtureEntry) allows(worker *Worker, shape Shape) bool {
+	if entry == nil || entry.UpperArea <= 0 {
+		return true
+	}
+	area := shape.Area()
+	if area == -1 {
+		return true
+	}
+	frac := area / float64(worker.W*worker.H)
+	return frac >= entry.LowerArea && frac <= entry.UpperArea
+}
@@ -0,0 +1,82 @@
+package primitive
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseMixture(t *testing.T) {
+	mixture, err := ParseMixture("1:1,8:3:alpha=200:size=10..30:order=5:convex=false:angle=20:area=0.01..0.2,4:4:area=0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Mixture{
+		{Type: ShapeTypeTriangle, Weight: 0.125},
+		{Type: ShapeTypePolygon, Weight: 0.375, Alpha: 200, SizeMin: 10, SizeMax: 30,
+			Order: 5, Convex: false, MinAngle: 20, LowerArea: 0.01, UpperArea: 0.2},
+		{Type: ShapeTypeCircle, Weight: 0.5, UpperArea: 0.5},
+	}
+	if len(mixture) != len(want) {
+		t.Fatalf("%d entries, want %d", len(mixture), len(want))
+	}
+	for i := range want {
+		if mixture[i] != want[i] {
+			t.Errorf("entry %d: %+v, want %+v", i, mixture[i], want[i])
+		}
+	}
+}
+
+func TestParseMixtureDefaults(t *testing.T) {
+	mixture, err := ParseMixture("8:1,10:1:size=15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	polygon, diamond := mixture[0], mixture[1]
+	if polygon.Order != 4 || !polygon.Convex || polygon.SizeMin != 40 || polygon.SizeMax != 40 {
+		t.Errorf("polygon defaults: %+v", polygon)
+	}
+	// a single size is fixed
+	if diamond.SizeMin != 15 || diamond.SizeMax != 15 {
+		t.Errorf("diamond size %g..%g, want 15..15", diamond.SizeMin, diamond.SizeMax)
+	}
+	var sum float64
+	for _, entry := range mixture {
+		sum += entry.Weight
+	}
+	if math.Abs(sum-1) > 1e-9 {
+		t.Errorf("weights sum to %g, want 1", sum)
+	}
+}
+
+func TestParseMixtureErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"1",
+		"x:1",
+		"1:x",
+		"1:0",
+		"1:-1",
+		"11:1",
+		"13:1",
+		"-1:1",
+		"1:1,",
+		"1:1:alpha",
+		"1:1:alpha=300",
+		"1:1:size=10",
+		"1:1:color=red",
+		"8:1:order=2",
+		"10:1:order=5",
+		"8:1:size=0",
+		"8:1:size=30..10",
+		"8:1:angle=180",
+		"8:1:convex=maybe",
+		"1:1:area=0.5..2",
+		"1:1:area=1..2..3",
+		// the angles of a convex quadrilateral average 90 degrees
+		"8:1:angle=90",
+	} {
+		if mixture, err := ParseMixture(s); err == nil {
+			t.Errorf("ParseMixture(%q) = %+v, want an error", s, mixture)
+		}
+	}
+}
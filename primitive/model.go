@@ -4,22 +4,51 @@ import (
 	"fmt"
 	"image"
 	"strings"
+	"time"
 
 	"github.com/fogleman/gg"
 )
 
 type Model struct {
-	Sw, Sh     int
-	Scale      float64
-	Background Color
-	Target     *image.RGBA
-	Current    *image.RGBA
-	Context    *gg.Context
-	Score      float64
-	Shapes     []Shape
-	Colors     []Color
-	Scores     []float64
-	Workers    []*Worker
+	Sw, Sh       int
+	Scale        float64
+	Background   Color
+	Target       *image.RGBA
+	Current      *image.RGBA
+	Buffer       *image.RGBA
+	Tiles        *Tiles
+	Context      *gg.Context
+	Metric       Metric
+	Weights      *image.Gray
+	Weight       float64
+	Region       *image.Gray
+	Linear       bool
+	Blend        BlendMode
+	Palette      Palette
+	Tone         *Tone
+	Inks         Palette
+	Symmetry     Symmetry
+	Orientation  *Orientation
+	Schedule     *SizeSchedule
+	Lattice      *Lattice
+	Occupancy    *image.Gray
+	Overlap      float64
+	Winner       ShapeType
+	Total        float64
+	Score        float64
+	InitialScore float64
+	Stop         StopCriteria
+	Started      time.Time
+	Shapes       []Shape
+	Colors       []Color
+	Blends       []BlendMode
+	Layers       []int
+	Scores       []float64
+	StepScores   []float64
+	Workers      []*Worker
+	levels       []*level
+	initial      *image.RGBA
+	canvas       *canvas
 }
 
 func NewModel(target image.Image, background Color, size, numWorkers int, blackThresh, lowerAreaThresh, upperAreaThresh float64, seed int64) *Model {
@@ -45,12 +74,16 @@ func NewModel(target image.Image, background Color, size, numWorkers int, blackT
 	model.Background = background
 	model.Target = imageToRGBA(target)
 	model.Current = uniformRGBA(target.Bounds(), background.NRGBA())
-	model.Score = differenceFull(model.Target, model.Current)
+	model.Buffer = image.NewRGBA(target.Bounds())
+	model.Metric = &RGBMetric{}
+	model.Weights = model.visibleWeights(nil)
 	model.Context = model.newContext()
 	for i := 0; i < numWorkers; i++ {
 		worker := NewWorker(model.Target, blackThresh, lowerAreaThresh, upperAreaThresh, seed+int64(i))
 		model.Workers = append(model.Workers, worker)
 	}
+	model.refresh()
+	model.Started = time.Now()
 	return model
 }
 
@@ -63,88 +96,222 @@ func (model *Model) newContext() *gg.Context {
 	return dc
 }
 
+func (model *Model) newCanvas() *canvas {
+	return newCanvas(model.Sw, model.Sh, model.Scale, model.Background, model.Linear)
+}
+
 func (model *Model) Frames(scoreDelta float64) []image.Image {
 	var result []image.Image
 	dc := model.newContext()
-	result = append(result, imageToRGBA(dc.Image()))
+	var canvas *canvas
+	if model.canvas != nil {
+		canvas = model.newCanvas()
+	}
+	frame := func() image.Image {
+		if canvas != nil {
+			return imageToRGBA(model.composite(canvas.Image()))
+		}
+		return imageToRGBA(model.composite(dc.Image()))
+	}
+	result = append(result, frame())
 	previous := 10.0
 	for i, shape := range model.Shapes {
 		c := model.Colors[i]
-		dc.SetRGBA255(c.R, c.G, c.B, c.A)
-		shape.Draw(dc, model.Scale)
-		dc.Fill()
+		if canvas != nil {
+			canvas.Draw(shape, c, model.Blends[i], model.Scale)
+		} else {
+			dc.SetRGBA255(c.R, c.G, c.B, c.A)
+			shape.Draw(dc, model.Scale)
+			dc.Fill()
+		}
 		score := model.Scores[i]
 		delta := previous - score
 		if delta >= scoreDelta {
 			previous = score
-			result = append(result, imageToRGBA(dc.Image()))
+			result = append(result, frame())
 		}
 	}
 	return result
 }
 
+// Image returns the rendered output, including the target's own pixels
+// outside the region.
+func (model *Model) Image() image.Image {
+	if model.canvas != nil {
+		return model.composite(model.canvas.Image())
+	}
+	return model.composite(model.Context.Image())
+}
+
+// SetLinear selects whether shapes are blended and their colors solved for
+// in linear light rather than in gamma encoded sRGB. It must be called
+// before any shapes are added.
+func (model *Model) SetLinear(linear bool) {
+	model.Linear = linear
+	model.canvas = nil
+	if linear || model.Blend != BlendNormal {
+		model.useCanvas()
+	}
+	for _, worker := range model.Workers {
+		for w := worker; w != nil; w = w.Coarse {
+			w.Linear = linear
+		}
+	}
+}
+
+// SetPalette restricts the colors of shapes added from now on to the given
+// palette. A nil palette lifts the restriction.
+func (model *Model) SetPalette(palette Palette) {
+	model.Palette = palette
+	for _, worker := range model.Workers {
+		for w := worker; w != nil; w = w.Coarse {
+			w.Palette = palette
+		}
+	}
+}
+
+// SetBlend sets the blend mode for the shapes added from now on.
+func (model *Model) SetBlend(mode BlendMode) {
+	model.Blend = mode
+	if mode != BlendNormal {
+		model.useCanvas()
+	}
+	for _, worker := range model.Workers {
+		for w := worker; w != nil; w = w.Coarse {
+			w.Blend = mode
+		}
+	}
+}
+
+// useCanvas renders the output with a canvas instead of gg, which cannot
+// blend in linear light or with blend modes, replaying the shapes so far.
+func (model *Model) useCanvas() {
+	if model.canvas != nil {
+		return
+	}
+	model.canvas = model.newCanvas()
+	for i, shape := range model.Shapes {
+		model.canvas.Draw(shape, model.Colors[i], model.Blends[i], model.Scale)
+	}
+}
+
 func (model *Model) SVG() string {
 	bg := model.Background
 	var lines []string
-	lines = append(lines, fmt.Sprintf("<svg xmlns=\"http://www.w3.org/2000/svg\" version=\"1.1\" width=\"%d\" height=\"%d\">", model.Sw, model.Sh))
-	lines = append(lines, fmt.Sprintf("<rect x=\"0\" y=\"0\" width=\"%d\" height=\"%d\" fill=\"#%02x%02x%02x%02x\" />", model.Sw, model.Sh, bg.R, bg.G, bg.B, bg.A))
-	lines = append(lines, fmt.Sprintf("<g transform=\"scale(%f) translate(0.5 0.5)\">", model.Scale))
+	lines = append(lines, fmt.Sprintf("<svg xmlns=\"http://www.w3.org/2000/svg\" xmlns:xlink=\"http://www.w3.org/1999/xlink\" version=\"1.1\" width=\"%d\" height=\"%d\">", model.Sw, model.Sh))
+	if bg.A > 0 {
+		lines = append(lines, fmt.Sprintf("<rect x=\"0\" y=\"0\" width=\"%d\" height=\"%d\" fill=\"#%02x%02x%02x\" fill-opacity=\"%f\" />", model.Sw, model.Sh, bg.R, bg.G, bg.B, float64(bg.A)/255))
+	}
+	group := fmt.Sprintf("<g transform=\"scale(%f) translate(0.5 0.5)\"", model.Scale)
+	if model.Linear {
+		group += " color-interpolation=\"linearRGB\""
+	}
+	lines = append(lines, group+">")
 	for i, shape := range model.Shapes {
 		c := model.Colors[i]
 		attrs := "fill=\"#%02x%02x%02x\" fill-opacity=\"%f\""
 		attrs = fmt.Sprintf(attrs, c.R, c.G, c.B, float64(c.A)/255)
+		if mode := model.Blends[i]; mode != BlendNormal {
+			attrs += fmt.Sprintf(" style=\"mix-blend-mode:%s\"", mode.CSS())
+		}
 		lines = append(lines, shape.SVG(attrs))
 	}
 	lines = append(lines, "</g>")
+	if region := model.regionSVG(); region != "" {
+		lines = append(lines, region)
+	}
 	lines = append(lines, "</svg>")
 	return strings.Join(lines, "\n")
 }
 
-func (model *Model) Add(shape Shape, alpha int) {
-	before := copyRGBA(model.Current)
+// Add draws shape and its symmetric copies onto the model. It reports
+// whether the shape was added, which fails when the shape leaves the region
+// or packed shapes leave no room for it.
+func (model *Model) Add(shape Shape, alpha int) bool {
+	shapes := model.Symmetry.copies(shape)
 	lines := shape.Rasterize()
-	color := computeColor(model.Target, model.Current, lines, alpha)
-	drawLines(model.Current, color, lines)
-	score := differencePartial(model.Target, before, model.Current, model.Score, lines)
+	var copies [][]Scanline
+	if len(shapes) > 1 {
+		copies, lines = rasterizeCopies(shapes)
+	}
+	// the whole shape is drawn to the output, so it must not be clipped
+	if !linesInRegion(model.Region, lines) || !fits(model.Occupancy, model.Overlap, lines) {
+		return false
+	}
+	color := blendColor(model.Metric, model.Blend, model.Linear, model.Target, model.Current, model.Weights, lines, alpha)
+	if model.Tone != nil {
+		color = model.Tone.project(color)
+	}
+	// draw into the buffer first so the metric sees the full before image
+	draw := func(c Color) float64 {
+		copyLines(model.Buffer, model.Current, lines)
+		drawCopies(model.Buffer, c, lines, copies, model.Blend, model.Linear)
+		return model.Metric.Delta(model.Target, model.Current, model.Buffer, model.Weights, lines)
+	}
+	if len(model.Palette) > 0 {
+		color, _ = pickPaletteColor(model.Palette, color, draw)
+	}
+	model.Total += draw(color)
+	model.Tiles.Update(model.Target, model.Current, model.Buffer, lines)
+	copyLines(model.Current, model.Buffer, lines)
+	model.updatePyramid(lines)
+	model.occupy(lines)
+	score := model.Metric.Score(model.Total, model.Weight)
 
 	model.Score = score
-	model.Shapes = append(model.Shapes, shape)
-	model.Colors = append(model.Colors, color)
-	model.Scores = append(model.Scores, score)
+	for _, shape := range shapes {
+		model.Shapes = append(model.Shapes, shape)
+		model.Colors = append(model.Colors, color)
+		model.Blends = append(model.Blends, model.Blend)
+		model.Layers = append(model.Layers, model.layer(color))
+		model.Scores = append(model.Scores, score)
 
-	model.Context.SetRGBA255(color.R, color.G, color.B, color.A)
-	shape.Draw(model.Context, model.Scale)
+		model.Context.SetRGBA255(color.R, color.G, color.B, color.A)
+		shape.Draw(model.Context, model.Scale)
+		if model.canvas != nil {
+			model.canvas.Draw(shape, color, model.Blend, model.Scale)
+		}
+	}
+	return true
 }
 
-func (model *Model) Step(shapeType ShapeType, alpha, repeat, idx, shapeTrials, age, hillClimbTrials int, fn NewShapeFunc) int {
+// Step searches for the best shape and adds it to the model, returning the
+// number of shapes evaluated and whether a shape was added. Nothing is added
+// when every candidate was rejected, e.g. for leaving the region or
+// colliding with packed shapes.
+func (model *Model) Step(shapeType ShapeType, alpha, repeat, idx, shapeTrials, age, hillClimbTrials int, fn NewShapeFunc) (int, bool) {
 	// v("Model Step")
 	//
+	model.scheduleSizes(idx)
 	state := model.runWorkers(shapeType, alpha, shapeTrials, age, hillClimbTrials, idx, fn)
 	// state = HillClimb(state, 1000).(*State)
-	model.Add(state.Shape, state.Alpha)
+	added := state.Energy() != rejected && model.Add(state.Shape, state.Alpha)
+	if added {
+		model.Winner = ShapeTypeOf(state.Shape)
+	}
 
-	for i := 0; i < repeat; i++ {
+	for i := 0; added && i < repeat; i++ {
 		v("here")
-		state.Worker.Init(model.Current, model.Score)
+		state.Worker.Init(model.Current, model.Total)
 		a := state.Energy()
 		state = HillClimb(state, 100).(*State)
 		b := state.Energy()
-		if a == b {
+		if a == b || !model.Add(state.Shape, state.Alpha) {
 			break
 		}
-		model.Add(state.Shape, state.Alpha)
 	}
-
-	// for _, w := range model.Workers[1:] {
-	// 	model.Workers[0].Heatmap.AddHeatmap(w.Heatmap)
-	// }
-	// SavePNG("heatmap.png", model.Workers[0].Heatmap.Image(0.5))
+	if added {
+		// Scores has an entry per shape, which can be several per step
+		model.StepScores = append(model.StepScores, model.Score)
+	}
 
 	counter := 0
 	for _, worker := range model.Workers {
-		counter += worker.Counter
+		for w := worker; w != nil; w = w.Coarse {
+			counter += w.Counter
+		}
 	}
-	return counter
+	return counter, added
 }
 
 func (model *Model) runWorkers(t ShapeType, a, n, age, m, idx int, fn NewShapeFunc) *State {
@@ -155,9 +322,18 @@ func (model *Model) runWorkers(t ShapeType, a, n, age, m, idx int, fn NewShapeFu
 		wm++
 	}
 	rand_val := model.Workers[0].Rnd.Float64()
+	deadline := model.deadline()
 	for i := 0; i < wn; i++ {
 		worker := model.Workers[i]
-		worker.Init(model.Current, model.Score)
+		worker.Init(model.Current, model.Total)
+		worker.Tiles = model.Tiles
+		worker.Deadline = deadline
+		coarse := worker.Coarse
+		for _, l := range model.levels {
+			coarse.Init(l.Current, l.Total)
+			coarse.Tiles = l.Tiles
+			coarse = coarse.Coarse
+		}
 		go model.runWorker(worker, t, a, n, age, wm, idx, fn, rand_val, ch)
 	}
 	var bestEnergy float64
@@ -174,5 +350,105 @@ func (model *Model) runWorkers(t ShapeType, a, n, age, m, idx int, fn NewShapeFu
 }
 
 func (model *Model) runWorker(worker *Worker, t ShapeType, a, n, age, m, idx int, fn NewShapeFunc, rand_val float64, ch chan *State) {
+	if t == ShapeTypeBlueDotSessions && worker.MixturePolicy == MixturePerWorker {
+		rand_val = worker.Rnd.Float64()
+	}
 	ch <- worker.BestHillClimbState(t, a, n, age, m, idx, fn, rand_val)
 }
+
+// SetMetric changes the error metric used by the model and its workers and
+// recomputes the current and initial scores under it.
+func (model *Model) SetMetric(metric Metric) {
+	model.Metric = metric
+	model.refresh()
+}
+
+// refresh recomputes the error totals and tiles of the model and its pyramid
+// levels after the metric, weights or region change, and passes them on to
+// the workers.
+func (model *Model) refresh() {
+	model.Weight = weightSum(model.Weights, model.Target.Bounds())
+	model.Tiles = NewTiles(model.Target, model.Current, model.Weights)
+	model.Total = model.Metric.Total(model.Target, model.Current, model.Weights)
+	model.Score = model.Metric.Score(model.Total, model.Weight)
+	if len(model.Shapes) == 0 {
+		model.initial = copyRGBA(model.Current)
+		model.InitialScore = model.Score
+	} else {
+		// score the image before any shapes under the current metric, so
+		// that the first plateau comparison does not mix metrics
+		total := model.Metric.Total(model.Target, model.initial, model.Weights)
+		model.InitialScore = model.Metric.Score(total, model.Weight)
+	}
+	weights := model.Weights
+	region := model.Region
+	occupancy := model.Occupancy
+	for _, l := range model.levels {
+		if weights != nil {
+			weights = halveGray(weights)
+		}
+		if region != nil {
+			region = halveGray(region)
+		}
+		if occupancy != nil {
+			occupancy = halveGray(occupancy)
+		}
+		l.Weights = weights
+		l.Region = region
+		l.Occupancy = occupancy
+		l.Weight = weightSum(weights, l.Target.Bounds())
+		l.Tiles = NewTiles(l.Target, l.Current, weights)
+		l.Total = model.Metric.Total(l.Target, l.Current, weights)
+	}
+	for _, worker := range model.Workers {
+		worker.Metric = model.Metric
+		worker.Weights = model.Weights
+		worker.Weight = model.Weight
+		worker.Region = model.Region
+		worker.Occupancy = model.Occupancy
+		worker.Overlap = model.Overlap
+		coarse := worker.Coarse
+		for _, l := range model.levels {
+			coarse.Metric = model.Metric
+			coarse.Weights = l.Weights
+			coarse.Weight = l.Weight
+			coarse.Region = l.Region
+			coarse.Occupancy = l.Occupancy
+			coarse.Overlap = model.Overlap
+			coarse = coarse.Coarse
+		}
+	}
+}
+
+// SetPlacement sets the placement strategy for new random shapes on every
+// worker, including the workers of coarse pyramid levels.
+func (model *Model) SetPlacement(placement Placement) {
+	for _, worker := range model.Workers {
+		for w := worker; w != nil; w = w.Coarse {
+			w.Placement = placement
+		}
+	}
+}
+
+// Heatmap returns the squared per-pixel error between the target and the
+// current image.
+func (model *Model) Heatmap() *Heatmap {
+	size := model.Target.Bounds().Size()
+	heatmap := NewHeatmap(size.X, size.Y)
+	heatmap.AddDifference(model.Target, model.Current)
+	return heatmap
+}
+
+// scanlineBounds returns the smallest rectangle containing all lines.
+func scanlineBounds(lines []Scanline) image.Rectangle {
+	var r image.Rectangle
+	for i, line := range lines {
+		lr := image.Rect(line.X1, line.Y, line.X2+1, line.Y+1)
+		if i == 0 {
+			r = lr
+		} else {
+			r = r.Union(lr)
+		}
+	}
+	return r
+}
@@ -0,0 +1,150 @@
+package primitive
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Orientation constrains the angle of oriented shapes (rotated rectangles,
+// rotated ellipses and quadratic curves) to within Tolerance degrees of a
+// preferred angle. The preferred angle is fixed, points toward a point
+// attractor, or follows the edges of the target as a flow field.
+type Orientation struct {
+	Tolerance float64
+	kind      orientationKind
+	angle     float64
+	x, y      float64
+	field     []float64
+	w, h      int
+}
+
+type orientationKind int
+
+const (
+	orientAngle orientationKind = iota
+	orientPoint
+	orientFlow
+)
+
+// ParseOrientation parses an orientation: angle:DEGREES, point:X,Y with X
+// and Y as fractions of the image size, or flow.
+func ParseOrientation(s string, tolerance float64) (*Orientation, error) {
+	name, args := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		name, args = s[:i], s[i+1:]
+	}
+	o := &Orientation{Tolerance: tolerance}
+	switch name {
+	case "angle":
+		a, err := strconv.ParseFloat(args, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid orientation angle: %s", s)
+		}
+		o.kind = orientAngle
+		o.angle = a
+	case "point":
+		split := strings.Split(args, ",")
+		if len(split) != 2 {
+			return nil, fmt.Errorf("invalid orientation point %q, expected point:X,Y", s)
+		}
+		x, err := strconv.ParseFloat(split[0], 64)
+		if err != nil {
+			return nil, err
+		}
+		y, err := strconv.ParseFloat(split[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		o.kind = orientPoint
+		o.x, o.y = x, y
+	case "flow":
+		if args != "" {
+			return nil, fmt.Errorf("flow orientation takes no arguments: %s", s)
+		}
+		o.kind = orientFlow
+	default:
+		return nil, fmt.Errorf("unrecognized orientation: %s", s)
+	}
+	return o, nil
+}
+
+// preferred returns the preferred angle, in degrees, at a position in the
+// image of worker.
+func (o *Orientation) preferred(worker *Worker, x, y float64) float64 {
+	switch o.kind {
+	case orientPoint:
+		dx := o.x*float64(worker.W) - x
+		dy := o.y*float64(worker.H) - y
+		return degrees(math.Atan2(dy, dx))
+	case orientFlow:
+		// the field is at full resolution, while coarse workers are smaller
+		fx := clampInt(int(x*float64(o.w)/float64(worker.W)), 0, o.w-1)
+		fy := clampInt(int(y*float64(o.h)/float64(worker.H)), 0, o.h-1)
+		return o.field[fy*o.w+fx]
+	}
+	return o.angle
+}
+
+// constrain returns the angle within tolerance of the preferred angle at a
+// position that is closest to angle. Shapes look the same when turned half
+// way around, so angles are compared modulo 180 degrees.
+func (o *Orientation) constrain(worker *Worker, x, y, angle float64) float64 {
+	p := o.preferred(worker, x, y)
+	d := math.Mod(angle-p, 180)
+	if d >= 90 {
+		d -= 180
+	} else if d < -90 {
+		d += 180
+	}
+	return p + clamp(d, -o.Tolerance, o.Tolerance)
+}
+
+// flowField computes the direction of the edges of the target at each
+// pixel from its smoothed structure tensor, so that shapes run along
+// contours instead of across them.
+func (o *Orientation) flowField(model *Model) {
+	size := model.Target.Bounds().Size()
+	w, h := size.X, size.Y
+	gx, gy := sobel(model.Target)
+	xx := make([]float64, w*h)
+	xy := make([]float64, w*h)
+	yy := make([]float64, w*h)
+	for i := range gx {
+		xx[i] = gx[i] * gx[i]
+		xy[i] = gx[i] * gy[i]
+		yy[i] = gy[i] * gy[i]
+	}
+	r := maxInt(minInt(w, h)/32, 1)
+	xx, xy, yy = boxMean(xx, w, h, r), boxMean(xy, w, h, r), boxMean(yy, w, h, r)
+	o.field = make([]float64, w*h)
+	for i := range o.field {
+		// the gradient is across edges, so turn it a quarter
+		o.field[i] = degrees(math.Atan2(2*xy[i], xx[i]-yy[i])/2) + 90
+	}
+	o.w, o.h = w, h
+}
+
+// SetOrientation constrains the angles of oriented shapes created or
+// mutated from now on. A nil orientation lifts the constraint.
+func (model *Model) SetOrientation(orientation *Orientation) {
+	if orientation != nil && orientation.kind == orientFlow {
+		orientation.flowField(model)
+	}
+	model.Orientation = orientation
+	for _, worker := range model.Workers {
+		for w := worker; w != nil; w = w.Coarse {
+			w.Orientation = orientation
+		}
+	}
+}
+
+// orient returns angle constrained by the worker's orientation at a
+// position, or angle itself without one.
+func (worker *Worker) orient(x, y, angle float64) float64 {
+	if worker.Orientation == nil {
+		return angle
+	}
+	return worker.Orientation.constrain(worker, x, y, angle)
+}
@@ -0,0 +1,79 @@
+package primitive
+
+import "image"
+
+// Packing keeps shapes from overlapping each other. An occupancy map marks
+// the pixels covered by shapes with 255, and a new shape may cover occupied
+// pixels for at most Overlap of its own pixels. A nil occupancy map allows
+// shapes to overlap freely.
+
+// SetPacking keeps the shapes added from now on from overlapping each other
+// by more than overlap, a fraction of the pixels of each new shape.
+func (model *Model) SetPacking(overlap float64) {
+	model.Occupancy = image.NewGray(model.Target.Bounds())
+	model.Overlap = overlap
+	model.refresh()
+}
+
+// fits reports whether lines cover the occupied pixels of occupancy for at
+// most overlap of their pixels.
+func fits(occupancy *image.Gray, overlap float64, lines []Scanline) bool {
+	if occupancy == nil {
+		return true
+	}
+	var total int
+	for _, line := range lines {
+		total += line.X2 - line.X1 + 1
+	}
+	limit := int(overlap * float64(total) * 255)
+	var covered int
+	for _, line := range lines {
+		i := occupancy.PixOffset(line.X1, line.Y)
+		for x := line.X1; x <= line.X2; x++ {
+			covered += int(occupancy.Pix[i])
+			i++
+		}
+		if covered > limit {
+			return false
+		}
+	}
+	return true
+}
+
+// free reports whether the pixel at x, y is not fully occupied, so that new
+// random shapes start in the space left between shapes.
+func free(occupancy *image.Gray, x, y int) bool {
+	if occupancy == nil {
+		return true
+	}
+	if !(image.Point{x, y}.In(occupancy.Rect)) {
+		return false
+	}
+	return occupancy.Pix[occupancy.PixOffset(x, y)] < 255
+}
+
+// occupy marks lines as occupied and updates the occupancy of each pyramid
+// level, touching only the pixels under the lines' bounding box.
+func (model *Model) occupy(lines []Scanline) {
+	if model.Occupancy == nil || len(lines) == 0 {
+		return
+	}
+	for _, line := range lines {
+		i := model.Occupancy.PixOffset(line.X1, line.Y)
+		for x := line.X1; x <= line.X2; x++ {
+			model.Occupancy.Pix[i] = 255
+			i++
+		}
+	}
+	r := scanlineBounds(lines)
+	occupancy := model.Occupancy
+	for _, l := range model.levels {
+		r = image.Rect(r.Min.X/2, r.Min.Y/2, (r.Max.X+1)/2, (r.Max.Y+1)/2)
+		r = r.Intersect(l.Occupancy.Bounds())
+		if r.Empty() {
+			return
+		}
+		downsampleGrayRect(l.Occupancy, occupancy, r)
+		occupancy = l.Occupancy
+	}
+}
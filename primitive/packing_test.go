@@ -0,0 +1,79 @@
+package primitive
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+)
+
+func TestFits(t *testing.T) {
+	occupancy := image.NewGray(image.Rect(0, 0, 10, 10))
+	// occupy the left half of the first row
+	for x := 0; x < 5; x++ {
+		occupancy.Pix[x] = 255
+	}
+	tests := []struct {
+		lines   []Scanline
+		overlap float64
+		want    bool
+	}{
+		{[]Scanline{{1, 0, 9, 0xffff}}, 0, true},
+		{[]Scanline{{0, 5, 9, 0xffff}}, 0, true},
+		{[]Scanline{{0, 4, 9, 0xffff}}, 0, false},
+		// 5 of 20 pixels are occupied
+		{[]Scanline{{0, 0, 9, 0xffff}, {1, 0, 9, 0xffff}}, 0.2, false},
+		{[]Scanline{{0, 0, 9, 0xffff}, {1, 0, 9, 0xffff}}, 0.25, true},
+	}
+	for _, test := range tests {
+		if got := fits(occupancy, test.overlap, test.lines); got != test.want {
+			t.Errorf("fits(%v, %g) = %v, want %v", test.lines, test.overlap, got, test.want)
+		}
+	}
+	if !fits(nil, 0, tests[2].lines) {
+		t.Error("fits without an occupancy map = false, want true")
+	}
+}
+
+func TestOccupy(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	w, h := 150, 133
+	model := NewModel(randomRGBA(rnd, w, h), Color{}, w, 1, 0, 0, 0, 1)
+	model.SetLevels(3)
+	model.SetPacking(0)
+	if len(model.levels) != 2 {
+		t.Fatalf("%d coarse levels, want 2", len(model.levels))
+	}
+	for i := 0; i < 10; i++ {
+		model.occupy(randomLines(rnd, w, h))
+	}
+	// every level matches the occupancy map halved from scratch
+	occupancy := model.Occupancy
+	for i, l := range model.levels {
+		occupancy = halveGray(occupancy)
+		for j := range occupancy.Pix {
+			if l.Occupancy.Pix[j] != occupancy.Pix[j] {
+				t.Fatalf("level %d: pixel %d is %d, want %d", i+1, j, l.Occupancy.Pix[j], occupancy.Pix[j])
+			}
+		}
+	}
+}
+
+func TestAddFull(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	w, h := 40, 30
+	model := NewModel(randomRGBA(rnd, w, h), Color{}, w, 1, 0, 0, 0, 1)
+	model.SetPacking(0)
+	worker := model.Workers[0]
+	if !model.Add(&Rectangle{worker, 0, 0, 19, h - 1}, 255) {
+		t.Fatal("could not add a shape to an empty image")
+	}
+	if model.Add(&Rectangle{worker, 15, 5, 25, 10}, 255) {
+		t.Error("added a shape overlapping a packed shape")
+	}
+	if len(model.Shapes) != 1 {
+		t.Errorf("%d shapes, want 1", len(model.Shapes))
+	}
+	if !model.Add(&Rectangle{worker, 20, 0, w - 1, h - 1}, 255) {
+		t.Error("could not add a shape to the free half")
+	}
+}
@@ -0,0 +1,257 @@
+package primitive
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// palette entries nearest to the unconstrained color that are tried for
+// each shape
+const paletteTries = 4
+
+// Palette is a fixed set of colors that shapes are restricted to. Alpha
+// values of the entries are ignored in favor of the shape's alpha.
+type Palette []Color
+
+// ParsePalette parses a list of hex colors separated by commas or spaces,
+// e.g. "#264653,#2a9d8f,#e9c46a".
+func ParsePalette(s string) (Palette, error) {
+	var palette Palette
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	}) {
+		c, err := parseHexColor(field)
+		if err != nil {
+			return nil, err
+		}
+		palette = append(palette, c)
+	}
+	if len(palette) == 0 {
+		return nil, fmt.Errorf("empty palette")
+	}
+	return palette, nil
+}
+
+// LoadPalette reads a palette file: a GIMP palette (.gpl), an Adobe swatch
+// exchange file (.ase), a JSON array of hex colors (.json) or any other text
+// file listing hex colors.
+func LoadPalette(path string) (Palette, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gpl":
+		return parseGPL(data)
+	case ".ase":
+		return parseASE(data)
+	case ".json":
+		var colors []string
+		if err := json.Unmarshal(data, &colors); err != nil {
+			return nil, err
+		}
+		return ParsePalette(strings.Join(colors, ","))
+	}
+	return ParsePalette(string(data))
+}
+
+// parseHexColor is like MakeHexColor but reports malformed colors.
+func parseHexColor(s string) (Color, error) {
+	x := strings.TrimPrefix(s, "#")
+	switch len(x) {
+	case 3, 4, 6, 8:
+	default:
+		return Color{}, fmt.Errorf("invalid hex color: %s", s)
+	}
+	if _, err := strconv.ParseUint(x, 16, 32); err != nil {
+		return Color{}, fmt.Errorf("invalid hex color: %s", s)
+	}
+	return MakeHexColor(x), nil
+}
+
+func parseGPL(data []byte) (Palette, error) {
+	var palette Palette
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line == "GIMP Palette" || strings.HasPrefix(line, "#") ||
+			strings.HasPrefix(line, "Name:") || strings.HasPrefix(line, "Columns:") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("invalid palette line: %s", line)
+		}
+		var rgb [3]int
+		for i := range rgb {
+			v, err := strconv.Atoi(fields[i])
+			if err != nil || v < 0 || v > 255 {
+				return nil, fmt.Errorf("invalid palette line: %s", line)
+			}
+			rgb[i] = v
+		}
+		palette = append(palette, Color{rgb[0], rgb[1], rgb[2], 255})
+	}
+	if len(palette) == 0 {
+		return nil, fmt.Errorf("empty palette")
+	}
+	return palette, scanner.Err()
+}
+
+func parseASE(data []byte) (Palette, error) {
+	r := bytes.NewReader(data)
+	var header struct {
+		Signature [4]byte
+		Major     uint16
+		Minor     uint16
+		Blocks    uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, err
+	}
+	if string(header.Signature[:]) != "ASEF" {
+		return nil, fmt.Errorf("not an ASE file")
+	}
+	var palette Palette
+	for i := 0; i < int(header.Blocks); i++ {
+		var block struct {
+			Type   uint16
+			Length uint32
+		}
+		if err := binary.Read(r, binary.BigEndian, &block); err != nil {
+			return nil, err
+		}
+		// check the length against the rest of the file before allocating
+		if int64(block.Length) > int64(r.Len()) {
+			return nil, fmt.Errorf("truncated ASE block")
+		}
+		body := make([]byte, block.Length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, err
+		}
+		if block.Type != 0x0001 {
+			// group start and end blocks
+			continue
+		}
+		c, err := parseASEColor(body)
+		if err != nil {
+			return nil, err
+		}
+		palette = append(palette, c)
+	}
+	if len(palette) == 0 {
+		return nil, fmt.Errorf("empty palette")
+	}
+	return palette, nil
+}
+
+func parseASEColor(body []byte) (Color, error) {
+	r := bytes.NewReader(body)
+	var nameLength uint16
+	if err := binary.Read(r, binary.BigEndian, &nameLength); err != nil {
+		return Color{}, err
+	}
+	// the name is UTF-16 and not needed
+	if _, err := r.Seek(int64(nameLength)*2, io.SeekCurrent); err != nil {
+		return Color{}, err
+	}
+	var model [4]byte
+	if err := binary.Read(r, binary.BigEndian, &model); err != nil {
+		return Color{}, err
+	}
+	read := func(n int) ([]float64, error) {
+		values := make([]float32, n)
+		if err := binary.Read(r, binary.BigEndian, values); err != nil {
+			return nil, err
+		}
+		result := make([]float64, n)
+		for i, v := range values {
+			result[i] = float64(v)
+		}
+		return result, nil
+	}
+	channel := func(v float64) int {
+		return clampInt(int(v*255+0.5), 0, 255)
+	}
+	switch string(model[:]) {
+	case "RGB ":
+		v, err := read(3)
+		if err != nil {
+			return Color{}, err
+		}
+		return Color{channel(v[0]), channel(v[1]), channel(v[2]), 255}, nil
+	case "Gray":
+		v, err := read(1)
+		if err != nil {
+			return Color{}, err
+		}
+		g := channel(v[0])
+		return Color{g, g, g, 255}, nil
+	case "CMYK":
+		v, err := read(4)
+		if err != nil {
+			return Color{}, err
+		}
+		k := 1 - v[3]
+		return Color{channel((1 - v[0]) * k), channel((1 - v[1]) * k), channel((1 - v[2]) * k), 255}, nil
+	case "LAB ":
+		v, err := read(3)
+		if err != nil {
+			return Color{}, err
+		}
+		cr, cg, cb := labToRGB(v[0]*100, v[1], v[2])
+		return Color{cr, cg, cb, 255}, nil
+	}
+	return Color{}, fmt.Errorf("unsupported ASE color model: %q", string(model[:]))
+}
+
+// Nearest returns the palette entry closest to c, with the alpha of c.
+func (palette Palette) Nearest(c Color) Color {
+	return palette.nearest(c, 1)[0]
+}
+
+// nearest returns up to n palette entries closest to c, nearest first, with
+// the alpha of c.
+func (palette Palette) nearest(c Color, n int) []Color {
+	result := make([]Color, len(palette))
+	copy(result, palette)
+	distance := func(p Color) int {
+		dr, dg, db := p.R-c.R, p.G-c.G, p.B-c.B
+		return dr*dr + dg*dg + db*db
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return distance(result[i]) < distance(result[j])
+	})
+	if len(result) > n {
+		result = result[:n]
+	}
+	for i := range result {
+		result[i].A = c.A
+	}
+	return result
+}
+
+// pickPaletteColor returns the palette entry near color with the lowest
+// total error, as computed by total, along with that error.
+func pickPaletteColor(palette Palette, color Color, total func(Color) float64) (Color, float64) {
+	best := color
+	bestTotal := math.Inf(1)
+	for _, c := range palette.nearest(color, paletteTries) {
+		t := total(c)
+		if t < bestTotal {
+			best = c
+			bestTotal = t
+		}
+	}
+	return best, bestTotal
+}
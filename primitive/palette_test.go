@@ -0,0 +1,46 @@
+package primitive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// aseFile returns an ASE file with one RGB color block whose length field is
+// set to length.
+func aseFile(length uint32) []byte {
+	var body bytes.Buffer
+	binary.Write(&body, binary.BigEndian, uint16(0)) // empty name
+	body.WriteString("RGB ")
+	binary.Write(&body, binary.BigEndian, [3]float32{1, 0.5, 0})
+	binary.Write(&body, binary.BigEndian, uint16(2)) // normal color
+	var file bytes.Buffer
+	file.WriteString("ASEF")
+	binary.Write(&file, binary.BigEndian, [2]uint16{1, 0})
+	binary.Write(&file, binary.BigEndian, uint32(1))
+	binary.Write(&file, binary.BigEndian, uint16(0x0001))
+	if length == 0 {
+		length = uint32(body.Len())
+	}
+	binary.Write(&file, binary.BigEndian, length)
+	file.Write(body.Bytes())
+	return file.Bytes()
+}
+
+func TestParseASE(t *testing.T) {
+	palette, err := parseASE(aseFile(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Color{255, 128, 0, 255}
+	if len(palette) != 1 || palette[0] != want {
+		t.Errorf("palette %v, want [%v]", palette, want)
+	}
+}
+
+func TestParseASETruncated(t *testing.T) {
+	// a block claiming 4 GiB must fail without allocating it
+	if _, err := parseASE(aseFile(0xffffffff)); err == nil {
+		t.Error("expected an error for a block longer than the file")
+	}
+}
@@ -14,6 +14,7 @@ type Polygon struct {
 	Order  int
 	Convex bool
 	MinAngle float64
+	// nominal size in full resolution pixels, the same at every level
 	SizeFactor float64
 	BoundsFactor int
 	X, Y   []float64
@@ -30,11 +31,11 @@ func NewRandomPolygon(worker *Worker, order int, convex bool, minAngle float64,
 	rnd := worker.Rnd
 	x := make([]float64, order)
 	y := make([]float64, order)
-	x[0] = rnd.Float64() * float64(w)
-	y[0] = rnd.Float64() * float64(h)
+	x[0], y[0] = worker.RandomPointF()
+	size := worker.maxSize(sizeFactor, 1)
 	for i := 1; i < order; i++ {
-		x[i] = clamp(x[0] + rnd.Float64()*sizeFactor - sizeFactor/2, float64(-boundsFactor), float64(w-1+boundsFactor))
-		y[i] = clamp(y[0] + rnd.Float64()*sizeFactor - sizeFactor/2, float64(-boundsFactor), float64(h-1+boundsFactor))
+		x[i] = clamp(x[0] + rnd.Float64()*size - size/2, float64(-boundsFactor), float64(w-1+boundsFactor))
+		y[i] = clamp(y[0] + rnd.Float64()*size - size/2, float64(-boundsFactor), float64(h-1+boundsFactor))
 	}
 	p := &Polygon{worker, order, convex, minAngle, sizeFactor, boundsFactor, x, y}
 	p.Mutate()
@@ -78,6 +79,7 @@ func (p *Polygon) Mutate() {
 	boundsFactor := p.BoundsFactor
 	sizeFactor := p.SizeFactor
 	rnd := p.Worker.Rnd
+	d := p.Worker.mutation(sizeFactor / 2)
 	for {
 		if rnd.Float64() < 0.25 {
 			i := rnd.Intn(p.Order)
@@ -85,8 +87,8 @@ func (p *Polygon) Mutate() {
 			p.X[i], p.Y[i], p.X[j], p.Y[j] = p.X[j], p.Y[j], p.X[i], p.Y[i]
 		} else {
 			i := rnd.Intn(p.Order)
-			p.X[i] = clamp(p.X[i]+rnd.NormFloat64()*sizeFactor/2, float64(-boundsFactor), float64(w-1+boundsFactor))
-			p.Y[i] = clamp(p.Y[i]+rnd.NormFloat64()*sizeFactor/2, float64(-boundsFactor), float64(h-1+boundsFactor))
+			p.X[i] = clamp(p.X[i]+rnd.NormFloat64()*d, float64(-boundsFactor), float64(w-1+boundsFactor))
+			p.Y[i] = clamp(p.Y[i]+rnd.NormFloat64()*d, float64(-boundsFactor), float64(h-1+boundsFactor))
 		}
 
 		if p.Valid() {
@@ -179,6 +181,25 @@ func (p *Polygon) Area() float64 {
 	return area
 }
 
+func (p *Polygon) Rescale(worker *Worker, factor float64) Shape {
+	a := p.Copy().(*Polygon)
+	a.Worker = worker
+	for i := range a.X {
+		a.X[i] *= factor
+		a.Y[i] *= factor
+	}
+	a.BoundsFactor = scaleInt(p.BoundsFactor, factor)
+	return a
+}
+
+func (p *Polygon) Transform(t Transform) Shape {
+	a := p.Copy().(*Polygon)
+	for i := range a.X {
+		a.X[i], a.Y[i] = t.point(p.Worker, p.X[i], p.Y[i])
+	}
+	return a
+}
+
 func mag (x, y float64) float64 {
 	return math.Sqrt(math.Pow(x, 2) + math.Pow(y, 2))
 }
@@ -0,0 +1,131 @@
+package primitive
+
+import "image"
+
+// coarsest pyramid levels are never made smaller than this many pixels
+const minLevelSize = 32
+
+// levelScale is the size of a pixel of a level in pixels of the level above
+// it. Halving averages 2x2 blocks and drops an odd last row or column, so the
+// scale is the same on both axes even when the sizes do not divide evenly.
+const levelScale = 2
+
+// level is a downsampled copy of the model's target and current images.
+// Each level is half the size of the one above it.
+type level struct {
+	Target    *image.RGBA
+	Current   *image.RGBA
+	Buffer    *image.RGBA
+	Tiles     *Tiles
+	Weights   *image.Gray
+	Weight    float64
+	Region    *image.Gray
+	Occupancy *image.Gray
+	Lines     []Scanline
+	Total     float64
+}
+
+// SetLevels configures coarse-to-fine search over the given number of
+// resolution levels. Random sampling and early hill climbing run on the
+// coarsest level and only the final refinement runs at full resolution.
+// A value of 1 disables the pyramid.
+func (model *Model) SetLevels(levels int) {
+	model.levels = nil
+	for _, worker := range model.Workers {
+		worker.Coarse = nil
+	}
+	target := model.Target
+	current := model.Current
+	parents := model.Workers
+	for i := 1; i < levels; i++ {
+		size := target.Bounds().Size()
+		if size.X/2 < minLevelSize || size.Y/2 < minLevelSize {
+			break
+		}
+		target = halveRGBA(target)
+		current = halveRGBA(current)
+		l := &level{}
+		l.Target = target
+		l.Current = current
+		l.Buffer = image.NewRGBA(target.Bounds())
+		model.levels = append(model.levels, l)
+		var coarse []*Worker
+		for _, parent := range parents {
+			worker := NewWorker(target, parent.BlackThresh, parent.LowerAreaThresh, parent.UpperAreaThresh, 0)
+			worker.Rnd = parent.Rnd
+			worker.Placement = parent.Placement
+			worker.Linear = parent.Linear
+			worker.Blend = parent.Blend
+			worker.Palette = parent.Palette
+			worker.Tone = parent.Tone
+			worker.Symmetry = parent.Symmetry
+			worker.Orientation = parent.Orientation
+			worker.Schedule = parent.Schedule
+			worker.Lattice = parent.Lattice
+			worker.MixturePolicy = parent.MixturePolicy
+			worker.SizeScale = parent.SizeScale / levelScale
+			parent.Coarse = worker
+			coarse = append(coarse, worker)
+		}
+		parents = coarse
+	}
+	model.refresh()
+	vv("SetLevels: levels=%d\n", len(model.levels)+1)
+}
+
+// updatePyramid propagates a change to lines of the current image to each
+// pyramid level, touching only the pixels under the lines' bounding box.
+func (model *Model) updatePyramid(lines []Scanline) {
+	if len(model.levels) == 0 || len(lines) == 0 {
+		return
+	}
+	r := scanlineBounds(lines)
+	current := model.Current
+	for _, l := range model.levels {
+		r = image.Rect(r.Min.X/2, r.Min.Y/2, (r.Max.X+1)/2, (r.Max.Y+1)/2)
+		r = r.Intersect(l.Current.Bounds())
+		if r.Empty() {
+			return
+		}
+		l.Lines = l.Lines[:0]
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			l.Lines = append(l.Lines, Scanline{y, r.Min.X, r.Max.X - 1, 0xffff})
+		}
+		downsampleRect(l.Buffer, current, r)
+		l.Total += model.Metric.Delta(l.Target, l.Current, l.Buffer, l.Weights, l.Lines)
+		l.Tiles.Update(l.Target, l.Current, l.Buffer, l.Lines)
+		copyLines(l.Current, l.Buffer, l.Lines)
+		current = l.Current
+	}
+}
+
+// halveRGBA returns a copy of src at half its width and height.
+func halveRGBA(src *image.RGBA) *image.RGBA {
+	size := src.Bounds().Size()
+	dst := image.NewRGBA(image.Rect(0, 0, size.X/2, size.Y/2))
+	downsampleRGBA(dst, src)
+	return dst
+}
+
+// downsampleRGBA fills dst by averaging 2x2 blocks of src.
+func downsampleRGBA(dst, src *image.RGBA) {
+	downsampleRect(dst, src, dst.Bounds())
+}
+
+// downsampleRect is like downsampleRGBA but only fills r of dst.
+func downsampleRect(dst, src *image.RGBA, r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := dst.PixOffset(r.Min.X, y)
+		j := src.PixOffset(r.Min.X*2, y*2)
+		k := src.PixOffset(r.Min.X*2, y*2+1)
+		for x := r.Min.X; x < r.Max.X; x++ {
+			for c := 0; c < 4; c++ {
+				sum := int(src.Pix[j+c]) + int(src.Pix[j+4+c]) + int(src.Pix[k+c]) + int(src.Pix[k+4+c])
+				dst.Pix[i+c] = uint8((sum + 2) / 4)
+			}
+			i += 4
+			j += 8
+			k += 8
+		}
+	}
+}
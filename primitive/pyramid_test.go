@@ -0,0 +1,48 @@
+package primitive
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestCoarseSizes(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	model := NewModel(randomRGBA(rnd, 512, 384), Color{}, 512, 1, 0, 0, 0, 1)
+	model.SetLevels(3)
+	depth := 0
+	for w := model.Workers[0]; w != nil; w = w.Coarse {
+		// sizes and mutations are the same in full resolution pixels
+		scale := math.Pow(levelScale, float64(depth))
+		if got := w.maxSize(32, 1) * scale; got != 32 {
+			t.Errorf("level %d: max size %g full resolution pixels, want 32", depth, got)
+		}
+		if got := w.mutation(16) * scale; got != 16 {
+			t.Errorf("level %d: mutation %g full resolution pixels, want 16", depth, got)
+		}
+		depth++
+	}
+	if depth != 3 {
+		t.Fatalf("%d levels, want 3", depth)
+	}
+}
+
+func TestCoarseShapeSize(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	model := NewModel(randomRGBA(rnd, 512, 384), Color{}, 512, 1, 0, 0, 0, 1)
+	model.SetLevels(3)
+	fine := model.Workers[0]
+	coarse := fine.Coarse.Coarse
+	const n = 2000
+	var fineSize, coarseSize float64
+	for i := 0; i < n; i++ {
+		fineSize += NewRandomRotatedEllipse(fine).Rx
+		shape := NewRandomRotatedEllipse(coarse).Rescale(fine, levelScale*levelScale)
+		coarseSize += shape.(*RotatedEllipse).Rx
+	}
+	// the minimum radius of a coarse pixel makes coarse shapes a little
+	// larger on average
+	if r := coarseSize / fineSize; r < 0.8 || r > 1.25 {
+		t.Errorf("rescaled coarse shapes are %.2f times the size of full resolution ones, want about 1", r)
+	}
+}
@@ -2,6 +2,7 @@ package primitive
 
 import (
 	"fmt"
+	"math"
 	"strings"
 
 	"github.com/fogleman/gg"
@@ -18,13 +19,13 @@ type Quadratic struct {
 
 func NewRandomQuadratic(worker *Worker) *Quadratic {
 	rnd := worker.Rnd
-	x1 := rnd.Float64() * float64(worker.W)
-	y1 := rnd.Float64() * float64(worker.H)
-	x2 := x1 + rnd.Float64()*40 - 20
-	y2 := y1 + rnd.Float64()*40 - 20
-	x3 := x2 + rnd.Float64()*40 - 20
-	y3 := y2 + rnd.Float64()*40 - 20
-	width := 1.0 / 2
+	x1, y1 := worker.RandomPointF()
+	d := worker.maxSize(20, 1)
+	x2 := x1 + rnd.Float64()*2*d - d
+	y2 := y1 + rnd.Float64()*2*d - d
+	x3 := x2 + rnd.Float64()*2*d - d
+	y3 := y2 + rnd.Float64()*2*d - d
+	width := worker.SizeScale / 2
 	q := &Quadratic{worker, x1, y1, x2, y2, x3, y3, width}
 	q.Mutate()
 	return q
@@ -55,17 +56,18 @@ func (q *Quadratic) Mutate() {
 	w := q.Worker.W
 	h := q.Worker.H
 	rnd := q.Worker.Rnd
+	d := q.Worker.mutation(16)
 	for {
 		switch rnd.Intn(3) {
 		case 0:
-			q.X1 = clamp(q.X1+rnd.NormFloat64()*16, -m, float64(w-1+m))
-			q.Y1 = clamp(q.Y1+rnd.NormFloat64()*16, -m, float64(h-1+m))
+			q.X1 = clamp(q.X1+rnd.NormFloat64()*d, -m, float64(w-1+m))
+			q.Y1 = clamp(q.Y1+rnd.NormFloat64()*d, -m, float64(h-1+m))
 		case 1:
-			q.X2 = clamp(q.X2+rnd.NormFloat64()*16, -m, float64(w-1+m))
-			q.Y2 = clamp(q.Y2+rnd.NormFloat64()*16, -m, float64(h-1+m))
+			q.X2 = clamp(q.X2+rnd.NormFloat64()*d, -m, float64(w-1+m))
+			q.Y2 = clamp(q.Y2+rnd.NormFloat64()*d, -m, float64(h-1+m))
 		case 2:
-			q.X3 = clamp(q.X3+rnd.NormFloat64()*16, -m, float64(w-1+m))
-			q.Y3 = clamp(q.Y3+rnd.NormFloat64()*16, -m, float64(h-1+m))
+			q.X3 = clamp(q.X3+rnd.NormFloat64()*d, -m, float64(w-1+m))
+			q.Y3 = clamp(q.Y3+rnd.NormFloat64()*d, -m, float64(h-1+m))
 		case 3:
 			q.Width = clamp(q.Width+rnd.NormFloat64(), 1, 16)
 		}
@@ -73,6 +75,25 @@ func (q *Quadratic) Mutate() {
 			break
 		}
 	}
+	q.orient()
+}
+
+// orient turns the curve about the middle of its ends so that the direction
+// from end to end respects the worker's orientation.
+func (q *Quadratic) orient() {
+	if q.Worker.Orientation == nil {
+		return
+	}
+	mx, my := (q.X1+q.X3)/2, (q.Y1+q.Y3)/2
+	a := degrees(math.Atan2(q.Y3-q.Y1, q.X3-q.X1))
+	theta := radians(q.Worker.orient(mx, my, a) - a)
+	turn := func(x, y float64) (float64, float64) {
+		x, y = rotate(x-mx, y-my, theta)
+		return x + mx, y + my
+	}
+	q.X1, q.Y1 = turn(q.X1, q.Y1)
+	q.X2, q.Y2 = turn(q.X2, q.Y2)
+	q.X3, q.Y3 = turn(q.X3, q.Y3)
 }
 
 func (q *Quadratic) Valid() bool {
@@ -102,3 +123,21 @@ func (q *Quadratic) Rasterize() []Scanline {
 func (r *Quadratic) Area() float64 {
 	return -1.0
 }
+
+func (q *Quadratic) Rescale(worker *Worker, factor float64) Shape {
+	a := *q
+	a.Worker = worker
+	a.X1, a.Y1 = q.X1*factor, q.Y1*factor
+	a.X2, a.Y2 = q.X2*factor, q.Y2*factor
+	a.X3, a.Y3 = q.X3*factor, q.Y3*factor
+	a.Width = q.Width * factor
+	return &a
+}
+
+func (q *Quadratic) Transform(t Transform) Shape {
+	a := *q
+	a.X1, a.Y1 = t.point(q.Worker, q.X1, q.Y1)
+	a.X2, a.Y2 = t.point(q.Worker, q.X2, q.Y2)
+	a.X3, a.Y3 = t.point(q.Worker, q.X3, q.Y3)
+	return &a
+}
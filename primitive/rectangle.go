@@ -15,10 +15,10 @@ type Rectangle struct {
 
 func NewRandomRectangle(worker *Worker) *Rectangle {
 	rnd := worker.Rnd
-	x1 := rnd.Intn(worker.W)
-	y1 := rnd.Intn(worker.H)
-	x2 := clampInt(x1+rnd.Intn(32)+1, 0, worker.W-1)
-	y2 := clampInt(y1+rnd.Intn(32)+1, 0, worker.H-1)
+	x1, y1 := worker.RandomPoint()
+	n := int(worker.maxSize(32, 1))
+	x2 := clampInt(x1+rnd.Intn(n)+1, 0, worker.W-1)
+	y2 := clampInt(y1+rnd.Intn(n)+1, 0, worker.H-1)
 	return &Rectangle{worker, x1, y1, x2, y2}
 }
 
@@ -58,13 +58,14 @@ func (r *Rectangle) Mutate() {
 	w := r.Worker.W
 	h := r.Worker.H
 	rnd := r.Worker.Rnd
+	d := r.Worker.mutation(16)
 	switch rnd.Intn(2) {
 	case 0:
-		r.X1 = clampInt(r.X1+int(rnd.NormFloat64()*16), 0, w-1)
-		r.Y1 = clampInt(r.Y1+int(rnd.NormFloat64()*16), 0, h-1)
+		r.X1 = clampInt(r.X1+int(rnd.NormFloat64()*d), 0, w-1)
+		r.Y1 = clampInt(r.Y1+int(rnd.NormFloat64()*d), 0, h-1)
 	case 1:
-		r.X2 = clampInt(r.X2+int(rnd.NormFloat64()*16), 0, w-1)
-		r.Y2 = clampInt(r.Y2+int(rnd.NormFloat64()*16), 0, h-1)
+		r.X2 = clampInt(r.X2+int(rnd.NormFloat64()*d), 0, w-1)
+		r.Y2 = clampInt(r.Y2+int(rnd.NormFloat64()*d), 0, h-1)
 	}
 }
 
@@ -74,7 +75,8 @@ func (r *Rectangle) Rasterize() []Scanline {
 	for y := y1; y <= y2; y++ {
 		lines = append(lines, Scanline{y, x1, x2, 0xffff})
 	}
-	return lines
+	// transformed copies may extend past the image
+	return cropScanlines(lines, r.Worker.W, r.Worker.H)
 }
 
 func (r *Rectangle) Area() float64 {
@@ -84,6 +86,30 @@ func (r *Rectangle) Area() float64 {
 	return float64(w*h)
 }
 
+func (r *Rectangle) Rescale(worker *Worker, factor float64) Shape {
+	x1, y1, x2, y2 := r.bounds()
+	// coordinates are inclusive, so scale the far edge of the last pixel
+	x2 = scaleInt(x2+1, factor) - 1
+	y2 = scaleInt(y2+1, factor) - 1
+	x1, y1 = scaleInt(x1, factor), scaleInt(y1, factor)
+	w, h := worker.W, worker.H
+	return &Rectangle{worker, clampInt(x1, 0, w-1), clampInt(y1, 0, h-1), clampInt(x2, 0, w-1), clampInt(y2, 0, h-1)}
+}
+
+func (r *Rectangle) Transform(t Transform) Shape {
+	x1, y1, x2, y2 := r.bounds()
+	if t.axisAligned() {
+		a := Rectangle{Worker: r.Worker}
+		a.X1, a.Y1 = t.pointInt(r.Worker, x1, y1)
+		a.X2, a.Y2 = t.pointInt(r.Worker, x2, y2)
+		return &a
+	}
+	// any other rotation needs a rotated rectangle
+	x, y := t.point(r.Worker, float64(x1+x2)/2, float64(y1+y2)/2)
+	angle := int(math.Round(t.angle(0)))
+	return &RotatedRectangle{r.Worker, int(math.Round(x)), int(math.Round(y)), x2 - x1 + 1, y2 - y1 + 1, angle}
+}
+
 
 type RotatedRectangle struct {
 	Worker *Worker
@@ -94,10 +120,10 @@ type RotatedRectangle struct {
 
 func NewRandomRotatedRectangle(worker *Worker) *RotatedRectangle {
 	rnd := worker.Rnd
-	x := rnd.Intn(worker.W)
-	y := rnd.Intn(worker.H)
-	sx := rnd.Intn(32) + 1
-	sy := rnd.Intn(32) + 1
+	x, y := worker.RandomPoint()
+	n := int(worker.maxSize(32, 1))
+	sx := rnd.Intn(n) + 1
+	sy := rnd.Intn(n) + 1
 	a := rnd.Intn(360)
 	r := &RotatedRectangle{worker, x, y, sx, sy, a}
 	r.Mutate()
@@ -129,16 +155,22 @@ func (r *RotatedRectangle) Mutate() {
 	w := r.Worker.W
 	h := r.Worker.H
 	rnd := r.Worker.Rnd
+	d := r.Worker.mutation(16)
 	switch rnd.Intn(3) {
 	case 0:
-		r.X = clampInt(r.X+int(rnd.NormFloat64()*16), 0, w-1)
-		r.Y = clampInt(r.Y+int(rnd.NormFloat64()*16), 0, h-1)
+		r.X = clampInt(r.X+int(rnd.NormFloat64()*d), 0, w-1)
+		r.Y = clampInt(r.Y+int(rnd.NormFloat64()*d), 0, h-1)
 	case 1:
-		r.Sx = clampInt(r.Sx+int(rnd.NormFloat64()*16), 1, w-1)
-		r.Sy = clampInt(r.Sy+int(rnd.NormFloat64()*16), 1, h-1)
+		r.Sx = clampInt(r.Sx+int(rnd.NormFloat64()*d), 1, w-1)
+		r.Sy = clampInt(r.Sy+int(rnd.NormFloat64()*d), 1, h-1)
 	case 2:
-		r.Angle = r.Angle + int(rnd.NormFloat64()*32)
+		r.Angle = r.Angle + int(rnd.NormFloat64()*2*d)
+	}
+	if r.Worker.Orientation != nil && r.Sy > r.Sx {
+		// the long side is the one that follows the orientation
+		r.Sx, r.Sy = r.Sy, r.Sx
 	}
+	r.Angle = int(math.Round(r.Worker.orient(float64(r.X), float64(r.Y), float64(r.Angle))))
 	// for !r.Valid() {
 	// 	r.Sx = clampInt(r.Sx+int(rnd.NormFloat64()*16), 0, w-1)
 	// 	r.Sy = clampInt(r.Sy+int(rnd.NormFloat64()*16), 0, h-1)
@@ -208,3 +240,18 @@ func (r *RotatedRectangle) Rasterize() []Scanline {
 func (r *RotatedRectangle) Area() float64 {
 	return float64(r.Sx * r.Sy)
 }
+
+func (r *RotatedRectangle) Rescale(worker *Worker, factor float64) Shape {
+	a := *r
+	a.Worker = worker
+	a.X, a.Y = scaleInt(r.X, factor), scaleInt(r.Y, factor)
+	a.Sx, a.Sy = scaleInt(r.Sx, factor), scaleInt(r.Sy, factor)
+	return &a
+}
+
+func (r *RotatedRectangle) Transform(t Transform) Shape {
+	a := *r
+	a.X, a.Y = t.pointInt(r.Worker, r.X, r.Y)
+	a.Angle = int(math.Round(t.angle(float64(r.Angle))))
+	return &a
+}
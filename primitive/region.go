@@ -0,0 +1,147 @@
+package primitive
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+
+	"github.com/fogleman/gg"
+)
+
+// attempts at placing a random point inside the region before giving up
+const regionTries = 64
+
+// A region restricts shapes to part of the target as a mask in which 255
+// marks pixels that shapes may cover. Pixels outside the region keep the
+// target's colors. A nil region allows every pixel.
+
+// RegionFromImage converts an image to a region, allowing the pixels whose
+// luminance is at least half.
+func RegionFromImage(im image.Image) *image.Gray {
+	dst := image.NewGray(im.Bounds())
+	draw.Draw(dst, dst.Rect, im, im.Bounds().Min, draw.Src)
+	for i, v := range dst.Pix {
+		if v >= 128 {
+			dst.Pix[i] = 255
+		} else {
+			dst.Pix[i] = 0
+		}
+	}
+	return dst
+}
+
+// SetRegion restricts shapes to the given region and starts the current
+// image from the target outside of it. region must have the same bounds as
+// the target and should be set before any shapes are added.
+func (model *Model) SetRegion(region *image.Gray) error {
+	if region != nil && region.Bounds() != model.Target.Bounds() {
+		return fmt.Errorf("region is %v but the target is %v", region.Bounds(), model.Target.Bounds())
+	}
+	model.Region = region
+	if region != nil {
+		for i, v := range region.Pix {
+			if v != 255 {
+				copy(model.Current.Pix[i*4:i*4+4], model.Target.Pix[i*4:i*4+4])
+			}
+		}
+	}
+	current := model.Current
+	for _, l := range model.levels {
+		downsampleRGBA(l.Current, current)
+		current = l.Current
+	}
+	model.refresh()
+	return nil
+}
+
+// inRegion reports whether region allows the pixel at x, y.
+func inRegion(region *image.Gray, x, y int) bool {
+	if region == nil {
+		return true
+	}
+	if !(image.Point{x, y}.In(region.Rect)) {
+		return false
+	}
+	return region.Pix[region.PixOffset(x, y)] == 255
+}
+
+// linesInRegion reports whether region allows every pixel of lines.
+func linesInRegion(region *image.Gray, lines []Scanline) bool {
+	if region == nil {
+		return true
+	}
+	for _, line := range lines {
+		i := region.PixOffset(line.X1, line.Y)
+		for x := line.X1; x <= line.X2; x++ {
+			if region.Pix[i] != 255 {
+				return false
+			}
+			i++
+		}
+	}
+	return true
+}
+
+// clipLines returns the parts of lines that region allows.
+func clipLines(region *image.Gray, lines []Scanline) []Scanline {
+	if region == nil {
+		return lines
+	}
+	var result []Scanline
+	for _, line := range lines {
+		i := region.PixOffset(line.X1, line.Y)
+		start := -1
+		for x := line.X1; x <= line.X2+1; x++ {
+			if x <= line.X2 && region.Pix[i] == 255 {
+				if start < 0 {
+					start = x
+				}
+			} else if start >= 0 {
+				result = append(result, Scanline{line.Y, start, x - 1, line.Alpha})
+				start = -1
+			}
+			i++
+		}
+	}
+	return result
+}
+
+// composite draws the target over im, which is rendered at the output size,
+// wherever the region does not allow shapes.
+func (model *Model) composite(im image.Image) image.Image {
+	if model.Region == nil {
+		return im
+	}
+	dc := gg.NewContextForImage(im)
+	dc.Scale(model.Scale, model.Scale)
+	dc.DrawImage(model.outside(), 0, 0)
+	return dc.Image()
+}
+
+// outside returns the target, transparent wherever the region allows
+// shapes.
+func (model *Model) outside() *image.NRGBA {
+	outside := image.NewNRGBA(model.Target.Bounds())
+	draw.Draw(outside, outside.Rect, model.Target, model.Target.Rect.Min, draw.Src)
+	for i, v := range model.Region.Pix {
+		a := int(outside.Pix[i*4+3])
+		outside.Pix[i*4+3] = uint8(a * (255 - int(v)) / 255)
+	}
+	return outside
+}
+
+// regionSVG returns an SVG image element that draws the target outside the
+// region over the shapes, like composite does for raster output, or an
+// empty string without a region.
+func (model *Model) regionSVG() string {
+	if model.Region == nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, model.outside())
+	return fmt.Sprintf("<image x=\"0\" y=\"0\" width=\"%d\" height=\"%d\" preserveAspectRatio=\"none\" xlink:href=\"data:image/png;base64,%s\" />",
+		model.Sw, model.Sh, base64.StdEncoding.EncodeToString(buf.Bytes()))
+}
@@ -0,0 +1,57 @@
+package primitive
+
+import (
+	"image"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestClipLines(t *testing.T) {
+	region := image.NewGray(image.Rect(0, 0, 10, 2))
+	for x := 2; x < 8; x++ {
+		if x != 5 {
+			region.Pix[region.PixOffset(x, 0)] = 255
+		}
+	}
+	got := clipLines(region, []Scanline{{0, 0, 9, 0xffff}, {1, 0, 9, 0xffff}})
+	want := []Scanline{{0, 2, 4, 0xffff}, {0, 6, 7, 0xffff}}
+	if len(got) != len(want) {
+		t.Fatalf("clipLines = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("clipLines = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestAddRegion(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	w, h := 40, 30
+	model := NewModel(randomRGBA(rnd, w, h), Color{}, w, 1, 0, 0, 0, 1)
+	// shapes may only cover the left half
+	region := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w/2; x++ {
+			region.Pix[region.PixOffset(x, y)] = 255
+		}
+	}
+	if err := model.SetRegion(region); err != nil {
+		t.Fatal(err)
+	}
+	worker := model.Workers[0]
+	if model.Add(&Rectangle{worker, 10, 5, 25, 10}, 255) {
+		t.Error("added a shape leaving the region")
+	}
+	if !model.Add(&Rectangle{worker, 0, 0, w/2 - 1, h - 1}, 255) {
+		t.Error("could not add a shape inside the region")
+	}
+	if len(model.Shapes) != 1 {
+		t.Errorf("%d shapes, want 1", len(model.Shapes))
+	}
+	// vector output keeps the target outside the region too
+	if svg := model.SVG(); !strings.Contains(svg, "<image ") {
+		t.Error("SVG does not draw the target outside the region")
+	}
+}
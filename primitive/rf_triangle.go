@@ -7,18 +7,23 @@ import (
 // Right Facing Triangle
 type RFTriangle struct {
   triangle Triangle
-  MutateFactor int
+  MutateFactor int // in full resolution pixels
   MutateYTol float64
 }
 
 func NewRandomRFTriangle(worker *Worker) *RFTriangle {
   tol := 0.2
   rnd := worker.Rnd
-  x1 := rnd.Intn(worker.W - 31)
-  y1 := rnd.Intn(worker.H/2)
+  // the vertical edge runs through the point chosen by the placement
+  x, y := worker.RandomPoint()
+  // as tall as half the image, unless a size schedule limits the area
+  h := worker.maxSize(float64(worker.H/2)/worker.SizeScale, 1)
+  e := rnd.Intn(clampInt(int(h), 1, worker.H/2)) + 1
+  x1 := x
+  y1 := clampInt(y-e/2, 0, worker.H-2)
   x2 := x1 // + rnd.Intn(31) - 15
-  y2 := y1 + rnd.Intn(worker.H/2) + 1
-  x3 := x1 + rnd.Intn(31)
+  y2 := clampInt(y1+e, y1+1, worker.H-1)
+  x3 := clampInt(x1+rnd.Intn(int(worker.maxSize(31, 1))), 0, worker.W-1)
   v := y2 - y1
   bottom := int(float64(y1) + tol*float64(v))
   y3 := bottom + rnd.Intn(v)
@@ -51,7 +56,7 @@ func (rft *RFTriangle) Mutate() {
   w := worker.W
   h := worker.H
   rnd := worker.Rnd
-  mfloat := float64(rft.MutateFactor)
+  mfloat := worker.mutation(float64(rft.MutateFactor))
   // mint := rft.MutateFactor
   for {
     switch rnd.Intn(3) {
@@ -59,12 +64,10 @@ func (rft *RFTriangle) Mutate() {
       rft.triangle.X1 = clampInt(t.X1+int(rnd.NormFloat64()*mfloat), 0, w-1)
       rft.triangle.Y1 = clampInt(t.Y1+int(rnd.NormFloat64()*mfloat), 0, h-1)
       rft.triangle.X2 = rft.triangle.X1
-      rft.triangle.Y2 = h-1 - rft.triangle.Y1
     case 1:
       rft.triangle.X2 = clampInt(t.X2+int(rnd.NormFloat64()*mfloat), 0, w-1)
       rft.triangle.Y2 = clampInt(t.Y2+int(rnd.NormFloat64()*mfloat), 0, h-1)
       rft.triangle.X1 = rft.triangle.X2
-      rft.triangle.Y1 = h-1 - rft.triangle.Y2
     case 2:
       rft.triangle.X3 = clampInt(t.X3+int(rnd.NormFloat64()*mfloat), 0, w-1)
       v := t.Y2 - t.Y1 + 1
@@ -98,3 +101,14 @@ func (t *RFTriangle) Rasterize() []Scanline {
 func (t *RFTriangle) Area() float64 {
   return t.triangle.Area()
 }
+
+func (t *RFTriangle) Rescale(worker *Worker, factor float64) Shape {
+	a := *t
+	a.triangle = *t.triangle.Rescale(worker, factor).(*Triangle)
+	return &a
+}
+
+// Transform returns a plain triangle, since copies no longer face right.
+func (t *RFTriangle) Transform(tr Transform) Shape {
+	return t.triangle.Transform(tr)
+}
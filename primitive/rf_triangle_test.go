@@ -0,0 +1,48 @@
+package primitive
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+)
+
+func TestRFTrianglePlacement(t *testing.T) {
+	w, h := 256, 256
+	target := image.NewRGBA(image.Rect(0, 0, w, h))
+	current := image.NewRGBA(image.Rect(0, 0, w, h))
+	// all of the error is in the tile at column 12 and row 3
+	for y := 48; y < 64; y++ {
+		for x := 192; x < 208; x++ {
+			target.Pix[target.PixOffset(x, y)] = 255
+		}
+	}
+	worker := NewWorker(target, 0, 0, 0, 1)
+	worker.Placement = PlacementError
+	worker.Tiles = NewTiles(target, current, nil)
+	for i := 0; i < 100; i++ {
+		rft := NewRandomRFTriangle(worker)
+		if !rft.Valid() {
+			t.Fatalf("invalid triangle %+v", rft.triangle)
+		}
+		// the vertical edge starts at the sampled point and moves by a
+		// single mutation
+		if x := rft.triangle.X1; x < 192-50 || x >= 208+50 {
+			t.Errorf("vertical edge at x=%d, far from the tile with error", x)
+		}
+	}
+}
+
+func TestRFTriangleSchedule(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	w, h := 200, 200
+	worker := NewWorker(randomRGBA(rnd, w, h), 0, 0, 0, 1)
+	worker.Schedule = &SizeSchedule{MaxStart: 0.01, MaxEnd: 0.01}
+	worker.UpperAreaThresh = 0.01
+	// the largest shape is 20 pixels square
+	for i := 0; i < 100; i++ {
+		rft := NewRandomRFTriangle(worker)
+		if v := rft.triangle.Y2 - rft.triangle.Y1; v > 20+2*rft.MutateFactor {
+			t.Errorf("vertical edge of %d pixels, want at most about 20", v)
+		}
+	}
+}
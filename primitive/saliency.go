@@ -0,0 +1,106 @@
+package primitive
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	// weight given to pixels with no detail at all, so that flat regions
+	// still get painted
+	saliencyFloor = 0.1
+	// spread of the center bias, relative to half the image size
+	saliencySigma = 0.6
+)
+
+// Saliency estimates which parts of an image draw the eye and returns them as
+// weights for SetWeights. Saliency is the local density of Sobel edges in
+// luminance, favoring the center of the image.
+func Saliency(im *image.RGBA) *image.Gray {
+	bounds := im.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	gx, gy := sobel(im)
+	edges := make([]float64, w*h)
+	for i := range edges {
+		edges[i] = math.Hypot(gx[i], gy[i])
+	}
+
+	// edge density is the mean gradient over a window
+	mean := boxMean(edges, w, h, maxInt(minInt(w, h)/32, 1))
+	density := make([]float64, w*h)
+	var peak float64
+	for y := 0; y < h; y++ {
+		dy := (float64(y) + 0.5 - float64(h)/2) / (float64(h) / 2)
+		for x := 0; x < w; x++ {
+			dx := (float64(x) + 0.5 - float64(w)/2) / (float64(w) / 2)
+			d := mean[y*w+x] * math.Exp(-(dx*dx+dy*dy)/(2*saliencySigma*saliencySigma))
+			density[y*w+x] = d
+			peak = math.Max(peak, d)
+		}
+	}
+
+	dst := image.NewGray(bounds)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			s := 0.0
+			if peak > 0 {
+				s = math.Sqrt(density[y*w+x] / peak)
+			}
+			v := saliencyFloor + (1-saliencyFloor)*s
+			dst.Pix[dst.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)] = uint8(v*255 + 0.5)
+		}
+	}
+	return dst
+}
+
+// sobel returns the horizontal and vertical Sobel gradients of the
+// luminance of an image, one value per pixel in row order.
+func sobel(im *image.RGBA) (gx, gy []float64) {
+	bounds := im.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	luma := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		i := im.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+		for x := 0; x < w; x++ {
+			luma[y*w+x] = luminance(im.Pix[i:])
+			i += 4
+		}
+	}
+	at := func(x, y int) float64 {
+		return luma[clampInt(y, 0, h-1)*w+clampInt(x, 0, w-1)]
+	}
+	gx = make([]float64, w*h)
+	gy = make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			gx[y*w+x] = at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) -
+				at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+			gy[y*w+x] = at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) -
+				at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+		}
+	}
+	return
+}
+
+// boxMean returns the mean of values over a square window of radius r
+// around each pixel, clipped to the image, via a summed area table.
+func boxMean(values []float64, w, h, r int) []float64 {
+	sat := make([]float64, (w+1)*(h+1))
+	for y := 1; y <= h; y++ {
+		var row float64
+		for x := 1; x <= w; x++ {
+			row += values[(y-1)*w+x-1]
+			sat[y*(w+1)+x] = sat[(y-1)*(w+1)+x] + row
+		}
+	}
+	mean := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		y0, y1 := maxInt(y-r, 0), minInt(y+r+1, h)
+		for x := 0; x < w; x++ {
+			x0, x1 := maxInt(x-r, 0), minInt(x+r+1, w)
+			sum := sat[y1*(w+1)+x1] - sat[y0*(w+1)+x1] - sat[y1*(w+1)+x0] + sat[y0*(w+1)+x0]
+			mean[y*w+x] = sum / float64((x1-x0)*(y1-y0))
+		}
+	}
+	return mean
+}
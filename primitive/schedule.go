@@ -0,0 +1,117 @@
+package primitive
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// SizeSchedule limits the area of shapes, as a fraction of the image area,
+// to a range that moves from its start to its end values over Count shapes,
+// so that a run goes from large blocks to fine detail. The range changes
+// linearly, or exponentially when Exponential is set and both values of a
+// bound are positive. It replaces the area thresholds given to NewModel.
+type SizeSchedule struct {
+	MaxStart, MaxEnd float64
+	MinStart, MinEnd float64
+	Count            int
+	Exponential      bool
+}
+
+// ParseSizeRange parses a START:END pair of area fractions. A single value
+// stays the same for the whole run.
+func ParseSizeRange(s string) (start, end float64, err error) {
+	split := strings.Split(s, ":")
+	if len(split) > 2 {
+		return 0, 0, fmt.Errorf("invalid size range %q, expected START:END", s)
+	}
+	start, err = strconv.ParseFloat(split[0], 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	end = start
+	if len(split) == 2 {
+		end, err = strconv.ParseFloat(split[1], 64)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if start < 0 || start > 1 || end < 0 || end > 1 {
+		return 0, 0, fmt.Errorf("size fractions must be between 0 and 1: %s", s)
+	}
+	return start, end, nil
+}
+
+// At returns the range of area fractions for the shape with the given index.
+func (s *SizeSchedule) At(idx int) (lower, upper float64) {
+	t := 1.0
+	if s.Count > 1 {
+		t = clamp(float64(idx)/float64(s.Count-1), 0, 1)
+	}
+	return s.at(t)
+}
+
+// at returns the range of area fractions at t, from 0 at the start of the
+// schedule to 1 at its end.
+func (s *SizeSchedule) at(t float64) (lower, upper float64) {
+	interpolate := func(start, end float64) float64 {
+		if s.Exponential && start > 0 && end > 0 {
+			return start * math.Pow(end/start, t)
+		}
+		return start + (end-start)*t
+	}
+	return interpolate(s.MinStart, s.MinEnd), interpolate(s.MaxStart, s.MaxEnd)
+}
+
+// Validate returns an error if the minimum area exceeds the maximum anywhere
+// in the schedule. Bounds that decay differently can cross between their
+// start and end values, so the whole schedule is checked.
+func (s *SizeSchedule) Validate() error {
+	const n = 1000
+	for i := 0; i <= n; i++ {
+		lower, upper := s.at(float64(i) / n)
+		if lower > upper {
+			return fmt.Errorf("minimum size %g exceeds maximum size %g %d%% of the way through the schedule", lower, upper, i*100/n)
+		}
+	}
+	return nil
+}
+
+// SetSizeSchedule limits the area of the shapes from now on by schedule.
+// A nil schedule keeps the area thresholds at their current values.
+func (model *Model) SetSizeSchedule(schedule *SizeSchedule) {
+	model.Schedule = schedule
+	for _, worker := range model.Workers {
+		for w := worker; w != nil; w = w.Coarse {
+			w.Schedule = schedule
+		}
+	}
+}
+
+// scheduleSizes sets the area thresholds of the workers for the shape with
+// the given index.
+func (model *Model) scheduleSizes(idx int) {
+	if model.Schedule == nil {
+		return
+	}
+	lower, upper := model.Schedule.At(idx)
+	vv("scheduleSizes: idx=%d, lower=%f, upper=%f\n", idx, lower, upper)
+	for _, worker := range model.Workers {
+		for w := worker; w != nil; w = w.Coarse {
+			w.LowerAreaThresh = lower
+			w.UpperAreaThresh = upper
+		}
+	}
+}
+
+// maxSize returns the largest size, in the worker's pixels, to give a new
+// random shape whose area is about k times the square of its size. It is n
+// full resolution pixels unless a size schedule limits the area of shapes.
+func (worker *Worker) maxSize(n, k float64) float64 {
+	if worker.Schedule == nil {
+		return math.Max(n*worker.SizeScale, 1)
+	}
+	area := worker.UpperAreaThresh * float64(worker.W*worker.H)
+	return math.Max(math.Sqrt(area/k), 1)
+}
@@ -0,0 +1,36 @@
+package primitive
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSizeScheduleValidate(t *testing.T) {
+	for _, test := range []struct {
+		schedule SizeSchedule
+		ok       bool
+	}{
+		{SizeSchedule{MaxStart: 0.2, MaxEnd: 0.01, MinStart: 0.05, MinEnd: 0.001}, true},
+		{SizeSchedule{MaxStart: 0.2, MaxEnd: 0.01, MinStart: 0.3, MinEnd: 0.001}, false},
+		{SizeSchedule{MaxStart: 0.2, MaxEnd: 0.01, MinStart: 0.05, MinEnd: 0.02}, false},
+		// an exponential maximum falls below a linear minimum halfway
+		{SizeSchedule{MaxStart: 0.5, MaxEnd: 0.0001, MinStart: 0.4, MinEnd: 0, Exponential: true}, false},
+		{SizeSchedule{MaxStart: 0.5, MaxEnd: 0.0001, MinStart: 0.4, MinEnd: 0}, true},
+	} {
+		err := test.schedule.Validate()
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("Validate(%+v) = %v, want ok=%v", test.schedule, err, test.ok)
+		}
+	}
+}
+
+func TestSizeScheduleAt(t *testing.T) {
+	s := SizeSchedule{MaxStart: 0.2, MaxEnd: 0.01, MinStart: 0.1, MinEnd: 0.005, Count: 11}
+	// the user's minimum is kept as given
+	if lower, upper := s.At(0); lower != 0.1 || upper != 0.2 {
+		t.Errorf("At(0) = %g, %g, want 0.1, 0.2", lower, upper)
+	}
+	if lower, upper := s.At(10); math.Abs(lower-0.005) > 1e-9 || math.Abs(upper-0.01) > 1e-9 {
+		t.Errorf("At(10) = %g, %g, want 0.005, 0.01", lower, upper)
+	}
+}
@@ -0,0 +1,65 @@
+package primitive
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Inks turn the model into a layered separation for printing: every shape
+// is printed with one of the inks, multiplied over the paper and the inks
+// below it, and the shapes of each ink form a layer. The optimizer picks
+// the ink of each shape, so layers interleave as the composite requires.
+
+// ParseInks parses a set of inks: cmyk, cmy or a list of hex colors.
+func ParseInks(s string) (Palette, error) {
+	switch strings.ToLower(s) {
+	case "cmyk":
+		return ParsePalette("#00ffff,#ff00ff,#ffff00,#000000")
+	case "cmy":
+		return ParsePalette("#00ffff,#ff00ff,#ffff00")
+	}
+	return ParsePalette(s)
+}
+
+// SetInks restricts shapes added from now on to the given inks with
+// multiply blending and records the ink of each shape in Layers. A nil set
+// of inks stops recording layers and leaves the palette and blend mode
+// alone.
+func (model *Model) SetInks(inks Palette) {
+	model.Inks = inks
+	if inks != nil {
+		model.SetPalette(inks)
+		model.SetBlend(BlendMultiply)
+	}
+}
+
+// layer returns the index of the ink with the given color, or 0 when the
+// model has no inks.
+func (model *Model) layer(c Color) int {
+	for i, ink := range model.Inks {
+		if ink.R == c.R && ink.G == c.G && ink.B == c.B {
+			return i
+		}
+	}
+	return 0
+}
+
+// LayerSVG returns the shapes printed with the given ink as an SVG with a
+// transparent background.
+func (model *Model) LayerSVG(layer int) string {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("<svg xmlns=\"http://www.w3.org/2000/svg\" version=\"1.1\" width=\"%d\" height=\"%d\">", model.Sw, model.Sh))
+	lines = append(lines, fmt.Sprintf("<g transform=\"scale(%f) translate(0.5 0.5)\">", model.Scale))
+	for i, shape := range model.Shapes {
+		if model.Layers[i] != layer {
+			continue
+		}
+		c := model.Colors[i]
+		attrs := "fill=\"#%02x%02x%02x\" fill-opacity=\"%f\""
+		attrs = fmt.Sprintf(attrs, c.R, c.G, c.B, float64(c.A)/255)
+		lines = append(lines, shape.SVG(attrs))
+	}
+	lines = append(lines, "</g>")
+	lines = append(lines, "</svg>")
+	return strings.Join(lines, "\n")
+}
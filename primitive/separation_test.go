@@ -0,0 +1,22 @@
+package primitive
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestSetInks(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	model := NewModel(randomRGBA(rnd, 40, 30), Color{}, 40, 1, 0, 0, 0, 1)
+	palette, _ := ParsePalette("#000,#fff")
+	model.SetPalette(palette)
+	model.SetInks(nil)
+	if len(model.Palette) != 2 || len(model.Workers[0].Palette) != 2 {
+		t.Errorf("SetInks(nil) changed the palette to %v", model.Palette)
+	}
+	inks, _ := ParseInks("cmy")
+	model.SetInks(inks)
+	if len(model.Palette) != 3 || model.Blend != BlendMultiply {
+		t.Errorf("inks: palette %v, blend %v", model.Palette, model.Blend)
+	}
+}
@@ -1,6 +1,10 @@
 package primitive
 
-import "github.com/fogleman/gg"
+import (
+	"fmt"
+
+	"github.com/fogleman/gg"
+)
 
 type Shape interface {
 	Rasterize() []Scanline
@@ -9,6 +13,12 @@ type Shape interface {
 	Draw(dc *gg.Context, scale float64)
 	SVG(attrs string) string
 	Area() float64
+	// Rescale returns a copy of the shape for use with worker, with all
+	// coordinates and sizes multiplied by factor.
+	Rescale(worker *Worker, factor float64) Shape
+	// Transform returns a copy of the shape mirrored and rotated about the
+	// center of the image. The copy may be a different type of shape.
+	Transform(t Transform) Shape
 }
 
 type BlueDotSessionsShapeConfig struct {
@@ -31,4 +41,49 @@ const (
 	ShapeTypeRightFacingTriangle
 	ShapeTypeDiamond
 	ShapeTypeBlueDotSessions
+	ShapeTypeTile
 )
+
+var shapeTypeNames = []string{
+	"any", "triangle", "rect", "ellipse", "circle", "rotatedrect", "beziers",
+	"rotatedellipse", "polygon", "right-facing-triangle", "diamond",
+	"blue-dot-sessions", "tile",
+}
+
+func (t ShapeType) String() string {
+	if t < 0 || int(t) >= len(shapeTypeNames) {
+		return fmt.Sprintf("ShapeType(%d)", int(t))
+	}
+	return shapeTypeNames[t]
+}
+
+// ShapeTypeOf returns the type of a shape, or ShapeTypeAny for shapes that
+// are not created by a shape type.
+func ShapeTypeOf(shape Shape) ShapeType {
+	switch s := shape.(type) {
+	case *Triangle:
+		return ShapeTypeTriangle
+	case *Rectangle:
+		return ShapeTypeRectangle
+	case *Ellipse:
+		if s.Circle {
+			return ShapeTypeCircle
+		}
+		return ShapeTypeEllipse
+	case *RotatedRectangle:
+		return ShapeTypeRotatedRectangle
+	case *Quadratic:
+		return ShapeTypeQuadratic
+	case *RotatedEllipse:
+		return ShapeTypeRotatedEllipse
+	case *Polygon:
+		return ShapeTypePolygon
+	case *RFTriangle:
+		return ShapeTypeRightFacingTriangle
+	case *Diamond:
+		return ShapeTypeDiamond
+	case *Tile:
+		return ShapeTypeTile
+	}
+	return ShapeTypeAny
+}
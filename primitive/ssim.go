@@ -0,0 +1,177 @@
+package primitive
+
+import (
+	"image"
+	"sync"
+)
+
+const (
+	// pixels within this distance make up the window around each pixel
+	ssimRadius = 3
+	ssimC1     = (0.01 * 255) * (0.01 * 255)
+	ssimC2     = (0.03 * 255) * (0.03 * 255)
+)
+
+// SSIMMetric measures structural dissimilarity: the error of each pixel is
+// 1 - SSIM over a square window of luminance around it. It favors edges and
+// texture over flat color, which suits detail shapes in portraits. Colors
+// are still solved for in RGB.
+type SSIMMetric struct {
+	scratch sync.Pool
+}
+
+func NewSSIMMetric() *SSIMMetric {
+	m := &SSIMMetric{}
+	m.scratch.New = func() interface{} { return &ssimScratch{} }
+	return m
+}
+
+// ssimScratch holds buffers reused across evaluations.
+type ssimScratch struct {
+	t, x                  []float64
+	st, sx, stt, sxx, stx []float64
+}
+
+func (m *SSIMMetric) Total(target, current *image.RGBA, weights *image.Gray) float64 {
+	r := target.Bounds()
+	s := m.scratch.Get().(*ssimScratch)
+	defer m.scratch.Put(s)
+	s.load(target, current, r, nil, nil)
+	return s.sum(r, r, weights)
+}
+
+func (m *SSIMMetric) Delta(target, before, after *image.RGBA, weights *image.Gray, lines []Scanline) float64 {
+	if len(lines) == 0 {
+		return 0
+	}
+	bounds := target.Bounds()
+	// pixels whose window contains a changed pixel
+	region := scanlineBounds(lines).Inset(-ssimRadius).Intersect(bounds)
+	// pixels that make up those windows
+	patch := region.Inset(-ssimRadius).Intersect(bounds)
+	s := m.scratch.Get().(*ssimScratch)
+	defer m.scratch.Put(s)
+	s.load(target, before, patch, nil, nil)
+	removed := s.sum(patch, region, weights)
+	s.load(target, before, patch, after, lines)
+	added := s.sum(patch, region, weights)
+	return added - removed
+}
+
+func (m *SSIMMetric) Score(total, weight float64) float64 {
+	return total / weight / 2
+}
+
+func (m *SSIMMetric) Color(target, current *image.RGBA, weights *image.Gray, lines []Scanline, alpha int) Color {
+	return computeColor(target, current, weights, lines, alpha)
+}
+
+// load reads the luminance of target and current over patch, taking pixels
+// under lines from after when it is given.
+func (s *ssimScratch) load(target, current *image.RGBA, patch image.Rectangle, after *image.RGBA, lines []Scanline) {
+	w, h := patch.Dx(), patch.Dy()
+	n := w * h
+	if cap(s.t) < n {
+		s.t = make([]float64, n)
+		s.x = make([]float64, n)
+	}
+	s.t = s.t[:n]
+	s.x = s.x[:n]
+	for y := 0; y < h; y++ {
+		i := target.PixOffset(patch.Min.X, patch.Min.Y+y)
+		for x := 0; x < w; x++ {
+			s.t[y*w+x] = luminance(target.Pix[i:])
+			s.x[y*w+x] = luminance(current.Pix[i:])
+			i += 4
+		}
+	}
+	for _, line := range lines {
+		if line.Y < patch.Min.Y || line.Y >= patch.Max.Y {
+			continue
+		}
+		i := after.PixOffset(line.X1, line.Y)
+		j := (line.Y-patch.Min.Y)*w + line.X1 - patch.Min.X
+		for x := line.X1; x <= line.X2; x++ {
+			s.x[j] = luminance(after.Pix[i:])
+			i += 4
+			j++
+		}
+	}
+}
+
+// sum returns the weighted total of 1 - SSIM for the pixels in region, using
+// windows clipped to patch.
+func (s *ssimScratch) sum(patch, region image.Rectangle, weights *image.Gray) float64 {
+	w, h := patch.Dx(), patch.Dy()
+	n := (w + 1) * (h + 1)
+	if cap(s.st) < n {
+		s.st = make([]float64, n)
+		s.sx = make([]float64, n)
+		s.stt = make([]float64, n)
+		s.sxx = make([]float64, n)
+		s.stx = make([]float64, n)
+	}
+	st, sx, stt, sxx, stx := s.st[:n], s.sx[:n], s.stt[:n], s.sxx[:n], s.stx[:n]
+	// summed area tables with a leading row and column of zeros
+	for x := 0; x <= w; x++ {
+		st[x], sx[x], stt[x], sxx[x], stx[x] = 0, 0, 0, 0, 0
+	}
+	for y := 1; y <= h; y++ {
+		var rt, rx, rtt, rxx, rtx float64
+		k := y * (w + 1)
+		st[k], sx[k], stt[k], sxx[k], stx[k] = 0, 0, 0, 0, 0
+		for x := 1; x <= w; x++ {
+			t := s.t[(y-1)*w+x-1]
+			v := s.x[(y-1)*w+x-1]
+			rt += t
+			rx += v
+			rtt += t * t
+			rxx += v * v
+			rtx += t * v
+			k := y*(w+1) + x
+			p := k - (w + 1)
+			st[k] = st[p] + rt
+			sx[k] = sx[p] + rx
+			stt[k] = stt[p] + rtt
+			sxx[k] = sxx[p] + rxx
+			stx[k] = stx[p] + rtx
+		}
+	}
+	var total float64
+	for y := region.Min.Y; y < region.Max.Y; y++ {
+		y0 := maxInt(y-ssimRadius, patch.Min.Y) - patch.Min.Y
+		y1 := minInt(y+ssimRadius+1, patch.Max.Y) - patch.Min.Y
+		j := -1
+		if weights != nil {
+			j = weights.PixOffset(region.Min.X, y)
+		}
+		for x := region.Min.X; x < region.Max.X; x++ {
+			x0 := maxInt(x-ssimRadius, patch.Min.X) - patch.Min.X
+			x1 := minInt(x+ssimRadius+1, patch.Max.X) - patch.Min.X
+			a := y0*(w+1) + x0
+			b := y0*(w+1) + x1
+			c := y1*(w+1) + x0
+			d := y1*(w+1) + x1
+			count := float64((x1 - x0) * (y1 - y0))
+			mt := (st[d] - st[b] - st[c] + st[a]) / count
+			mx := (sx[d] - sx[b] - sx[c] + sx[a]) / count
+			vt := (stt[d]-stt[b]-stt[c]+stt[a])/count - mt*mt
+			vx := (sxx[d]-sxx[b]-sxx[c]+sxx[a])/count - mx*mx
+			cov := (stx[d]-stx[b]-stx[c]+stx[a])/count - mt*mx
+			ssim := (2*mt*mx + ssimC1) * (2*cov + ssimC2) /
+				((mt*mt + mx*mx + ssimC1) * (vt + vx + ssimC2))
+			if j >= 0 {
+				total += (1 - ssim) * float64(weights.Pix[j]) / 255
+				j++
+			} else {
+				total += 1 - ssim
+			}
+		}
+	}
+	return total
+}
+
+// luminance returns the Rec. 601 luma of the first pixel in p.
+func luminance(p []uint8) float64 {
+	return 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+}
@@ -1,11 +1,25 @@
 package primitive
 
+import "math"
+
+const (
+	// mutation scale adapts every this many moves using the 1/5th success rule
+	adaptInterval  = 10
+	minMutateScale = 0.05
+	maxMutateScale = 4.0
+)
+
 type State struct {
 	Worker      *Worker
 	Shape       Shape
 	Alpha       int
 	MutateAlpha bool
 	Score       float64
+	MutateScale float64
+	Moves       int
+	Rejected    int
+	// the mixture entry the shape was drawn from, if any
+	Entry *MixtureEntry
 }
 
 func NewState(worker *Worker, shape Shape, alpha int) *State {
@@ -14,24 +28,33 @@ func NewState(worker *Worker, shape Shape, alpha int) *State {
 		alpha = 128
 		mutateAlpha = true
 	}
-	return &State{worker, shape, alpha, mutateAlpha, -1}
+	return &State{worker, shape, alpha, mutateAlpha, -1, 1, 0, 0, nil}
 }
 
 func (state *State) Energy() float64 {
 	if state.Score < 0 {
-		state.Score = state.Worker.Energy(state.Shape, state.Alpha)
+		if !state.Entry.allows(state.Worker, state.Shape) {
+			state.Score = rejected
+		} else {
+			state.Score = state.Worker.Energy(state.Shape, state.Alpha)
+		}
 	}
 	return state.Score
 }
 
 func (state *State) DoMove() interface{} {
-	rnd := state.Worker.Rnd
+	worker := state.Worker
+	rnd := worker.Rnd
 	oldState := state.Copy()
+	state.adapt()
+	worker.MutateScale = state.MutateScale * sizeScale(worker, state.Shape)
 	state.Shape.Mutate()
+	worker.MutateScale = 1
 	if state.MutateAlpha {
 		state.Alpha = clampInt(state.Alpha+rnd.Intn(21)-10, 1, 255)
 	}
 	state.Score = -1
+	state.Moves++
 	return oldState
 }
 
@@ -40,9 +63,39 @@ func (state *State) UndoMove(undo interface{}) {
 	state.Shape = oldState.Shape
 	state.Alpha = oldState.Alpha
 	state.Score = oldState.Score
+	state.Rejected++
 }
 
 func (state *State) Copy() Annealable {
 	return &State{
-		state.Worker, state.Shape.Copy(), state.Alpha, state.MutateAlpha, state.Score}
+		state.Worker, state.Shape.Copy(), state.Alpha, state.MutateAlpha, state.Score,
+		state.MutateScale, state.Moves, state.Rejected, state.Entry}
+}
+
+// adapt grows the mutation scale when more than a fifth of recent moves were
+// improvements and shrinks it otherwise.
+func (state *State) adapt() {
+	if state.Moves < adaptInterval {
+		return
+	}
+	rate := float64(state.Moves-state.Rejected) / float64(state.Moves)
+	if rate > 0.2 {
+		state.MutateScale /= 0.82
+	} else if rate < 0.2 {
+		state.MutateScale *= 0.82
+	}
+	state.MutateScale = clamp(state.MutateScale, minMutateScale, maxMutateScale)
+	state.Moves = 0
+	state.Rejected = 0
+}
+
+// sizeScale shrinks mutations for small shapes so that late, detailed shapes
+// are not thrown around by steps meant for large ones. Sizes are measured in
+// full resolution pixels.
+func sizeScale(worker *Worker, shape Shape) float64 {
+	area := shape.Area()
+	if area <= 1 {
+		return 1
+	}
+	return clamp(math.Sqrt(area)/worker.SizeScale/16, 0.25, 1)
 }
@@ -0,0 +1,52 @@
+package primitive
+
+import "time"
+
+// StopCriteria describes when a run should end besides reaching a fixed
+// number of shapes. A zero value disables the corresponding criterion.
+type StopCriteria struct {
+	TargetScore    float64
+	TimeLimit      time.Duration
+	PlateauSteps   int
+	PlateauEpsilon float64
+}
+
+func (s StopCriteria) Enabled() bool {
+	return s.TargetScore > 0 || s.TimeLimit > 0 || s.PlateauSteps > 0
+}
+
+// Done reports whether any of the model's stopping criteria has been met.
+func (model *Model) Done() bool {
+	s := model.Stop
+	if s.TargetScore > 0 && model.Score <= s.TargetScore {
+		return true
+	}
+	if s.TimeLimit > 0 && time.Since(model.Started) >= s.TimeLimit {
+		return true
+	}
+	if s.PlateauSteps > 0 {
+		n := len(model.StepScores)
+		if n < s.PlateauSteps {
+			return false
+		}
+		previous := model.InitialScore
+		if n > s.PlateauSteps {
+			previous = model.StepScores[n-s.PlateauSteps-1]
+		}
+		for _, score := range model.StepScores[n-s.PlateauSteps:] {
+			if previous-score >= s.PlateauEpsilon {
+				return false
+			}
+			previous = score
+		}
+		return true
+	}
+	return false
+}
+
+func (model *Model) deadline() time.Time {
+	if model.Stop.TimeLimit <= 0 {
+		return time.Time{}
+	}
+	return model.Started.Add(model.Stop.TimeLimit)
+}
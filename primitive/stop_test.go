@@ -0,0 +1,30 @@
+package primitive
+
+import "testing"
+
+func TestDonePlateau(t *testing.T) {
+	stop := StopCriteria{PlateauSteps: 3, PlateauEpsilon: 0.01}
+	tests := []struct {
+		scores []float64
+		done   bool
+	}{
+		{nil, false},
+		{[]float64{0.5, 0.5}, false},
+		// the first step is compared with the initial score
+		{[]float64{0.5, 0.5, 0.5}, false},
+		{[]float64{0.995, 0.99, 0.985}, true},
+		{[]float64{0.5, 0.5, 0.5, 0.5}, true},
+		{[]float64{0.9, 0.8, 0.7, 0.695}, false},
+		{[]float64{0.9, 0.8, 0.7, 0.695, 0.69, 0.685}, true},
+	}
+	for _, test := range tests {
+		model := &Model{Stop: stop, InitialScore: 1, StepScores: test.scores}
+		// shapes of symmetric copies repeat the score of their step
+		for _, score := range test.scores {
+			model.Scores = append(model.Scores, score, score, score, score)
+		}
+		if done := model.Done(); done != test.done {
+			t.Errorf("Done() with step scores %v = %v, want %v", test.scores, done, test.done)
+		}
+	}
+}
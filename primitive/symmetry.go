@@ -0,0 +1,154 @@
+package primitive
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Transform mirrors and rotates the plane about the center of an image.
+// The mirror, left to right, is applied before the rotation, which is in
+// degrees clockwise.
+type Transform struct {
+	Mirror bool
+	Angle  float64
+}
+
+// point maps a position in the image of worker.
+func (t Transform) point(worker *Worker, x, y float64) (float64, float64) {
+	cx, cy := float64(worker.W-1)/2, float64(worker.H-1)/2
+	dx, dy := x-cx, y-cy
+	if t.Mirror {
+		dx = -dx
+	}
+	dx, dy = rotate(dx, dy, radians(t.Angle))
+	return cx + dx, cy + dy
+}
+
+// pointInt maps a pixel position in the image of worker.
+func (t Transform) pointInt(worker *Worker, x, y int) (int, int) {
+	fx, fy := t.point(worker, float64(x), float64(y))
+	return int(math.Round(fx)), int(math.Round(fy))
+}
+
+// angle maps the orientation of a shape, in degrees.
+func (t Transform) angle(a float64) float64 {
+	if t.Mirror {
+		a = 180 - a
+	}
+	return a + t.Angle
+}
+
+// axisAligned reports whether the transform keeps horizontal lines
+// horizontal or vertical.
+func (t Transform) axisAligned() bool {
+	return math.Mod(t.Angle, 90) == 0
+}
+
+// quarterTurn reports whether the transform swaps horizontal and vertical.
+func (t Transform) quarterTurn() bool {
+	return math.Mod(math.Abs(t.Angle), 180) == 90
+}
+
+// Symmetry is the set of transforms that produce the copies drawn along
+// with every shape, excluding the identity.
+type Symmetry []Transform
+
+// ParseSymmetry parses a symmetry: x mirrors left to right, y mirrors top
+// to bottom, xy does both, rN repeats shapes N times around the center and
+// dN also mirrors each of those copies, as in a kaleidoscope.
+func ParseSymmetry(s string) (Symmetry, error) {
+	switch s {
+	case "", "none":
+		return nil, nil
+	case "x":
+		return Symmetry{{true, 0}}, nil
+	case "y":
+		return Symmetry{{true, 180}}, nil
+	case "xy":
+		return Symmetry{{true, 0}, {true, 180}, {false, 180}}, nil
+	}
+	if len(s) > 1 && (s[0] == 'r' || s[0] == 'd') {
+		n, err := strconv.Atoi(s[1:])
+		if err != nil || n < 2 {
+			return nil, fmt.Errorf("invalid symmetry order: %s", s)
+		}
+		var symmetry Symmetry
+		for i := 0; i < n; i++ {
+			angle := float64(i) * 360 / float64(n)
+			if i > 0 {
+				symmetry = append(symmetry, Transform{false, angle})
+			}
+			if s[0] == 'd' {
+				symmetry = append(symmetry, Transform{true, angle})
+			}
+		}
+		return symmetry, nil
+	}
+	return nil, fmt.Errorf("unrecognized symmetry: %s", s)
+}
+
+// copies returns the shape followed by its symmetric copies.
+func (symmetry Symmetry) copies(shape Shape) []Shape {
+	shapes := []Shape{shape}
+	for _, t := range symmetry {
+		shapes = append(shapes, shape.Transform(t))
+	}
+	return shapes
+}
+
+// rasterizeCopies rasterizes each of shapes separately and also returns the
+// union of their lines, which covers each pixel once.
+func rasterizeCopies(shapes []Shape) (copies [][]Scanline, union []Scanline) {
+	for _, shape := range shapes {
+		// shapes rasterize into their worker's shared buffer
+		lines := append([]Scanline(nil), shape.Rasterize()...)
+		copies = append(copies, lines)
+		union = append(union, lines...)
+	}
+	sort.Slice(union, func(i, j int) bool {
+		if union[i].Y != union[j].Y {
+			return union[i].Y < union[j].Y
+		}
+		return union[i].X1 < union[j].X1
+	})
+	n := 0
+	for _, line := range union {
+		line.Alpha = 0xffff
+		if n > 0 {
+			last := &union[n-1]
+			if last.Y == line.Y && line.X1 <= last.X2+1 {
+				last.X2 = maxInt(last.X2, line.X2)
+				continue
+			}
+		}
+		union[n] = line
+		n++
+	}
+	return copies, union[:n]
+}
+
+// drawCopies blends a color over lines, or over each of copies in turn when
+// the shape is drawn with symmetric copies.
+func drawCopies(im *image.RGBA, c Color, lines []Scanline, copies [][]Scanline, mode BlendMode, linear bool) {
+	if copies == nil {
+		blendLines(im, c, lines, mode, linear)
+		return
+	}
+	for _, l := range copies {
+		blendLines(im, c, l, mode, linear)
+	}
+}
+
+// SetSymmetry draws every shape added from now on together with its
+// symmetric copies, which are chosen and colored as one.
+func (model *Model) SetSymmetry(symmetry Symmetry) {
+	model.Symmetry = symmetry
+	for _, worker := range model.Workers {
+		for w := worker; w != nil; w = w.Coarse {
+			w.Symmetry = symmetry
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package primitive
+
+import (
+	"image"
+	"math/rand"
+)
+
+const tileSize = 16
+
+// Tiles tracks the weighted squared error between a target and a current
+// image per square tile, so that drawing a shape only touches the tiles it
+// covers.
+// Tile errors are kept in a Fenwick tree so that picking a tile in
+// proportion to its error does not depend on the number of tiles.
+type Tiles struct {
+	W, H       int
+	Size       int
+	Cols, Rows int
+	Errors     []uint64
+	Total      uint64
+	Weights    *image.Gray
+	tree       []uint64
+}
+
+func NewTiles(target, current *image.RGBA, weights *image.Gray) *Tiles {
+	size := target.Bounds().Size()
+	t := &Tiles{}
+	t.W = size.X
+	t.H = size.Y
+	t.Size = tileSize
+	t.Cols = (t.W + t.Size - 1) / t.Size
+	t.Rows = (t.H + t.Size - 1) / t.Size
+	t.Weights = weights
+	t.Errors = make([]uint64, t.Cols*t.Rows)
+	t.tree = make([]uint64, t.Cols*t.Rows+1)
+	for y := 0; y < t.H; y++ {
+		t.add(target, current, Scanline{y, 0, t.W - 1, 0xffff}, 1)
+	}
+	return t
+}
+
+// Update accounts for lines having changed from before to after.
+func (t *Tiles) Update(target, before, after *image.RGBA, lines []Scanline) {
+	for _, line := range lines {
+		t.add(target, before, line, -1)
+		t.add(target, after, line, 1)
+	}
+}
+
+// Sample picks a random pixel, choosing its tile with probability
+// proportional to the tile's error.
+func (t *Tiles) Sample(rnd *rand.Rand) (x, y int) {
+	if t.Total == 0 {
+		return rnd.Intn(t.W), rnd.Intn(t.H)
+	}
+	r := uint64(rnd.Int63n(int64(t.Total)))
+	// descend the Fenwick tree to the first tile whose prefix sum exceeds r
+	i := 0
+	step := 1
+	for step*2 <= len(t.Errors) {
+		step *= 2
+	}
+	for ; step > 0; step /= 2 {
+		if i+step <= len(t.Errors) && t.tree[i+step] <= r {
+			i += step
+			r -= t.tree[i]
+		}
+	}
+	col, row := i%t.Cols, i/t.Cols
+	x0, y0 := col*t.Size, row*t.Size
+	x1, y1 := minInt(x0+t.Size, t.W), minInt(y0+t.Size, t.H)
+	return x0 + rnd.Intn(x1-x0), y0 + rnd.Intn(y1-y0)
+}
+
+// add adds (sign > 0) or removes (sign < 0) the error of a line.
+func (t *Tiles) add(target, current *image.RGBA, line Scanline, sign int) {
+	row := line.Y / t.Size
+	for x1 := line.X1; x1 <= line.X2; {
+		col := x1 / t.Size
+		x2 := minInt(line.X2, (col+1)*t.Size-1)
+		total := lineError(target, current, t.Weights, line.Y, x1, x2)
+		if sign < 0 {
+			total = -total
+		}
+		t.addTile(row*t.Cols+col, total)
+		x1 = x2 + 1
+	}
+}
+
+// addTile adds delta to a tile's error. Negative deltas rely on unsigned
+// wraparound, which is exact as long as the resulting sums are non-negative.
+func (t *Tiles) addTile(i int, delta uint64) {
+	t.Errors[i] += delta
+	t.Total += delta
+	for j := i + 1; j < len(t.tree); j += j & -j {
+		t.tree[j] += delta
+	}
+}
+
+// lineError returns the squared RGBA error of a line, with each pixel
+// multiplied by its weight. Without weights every pixel weighs 255.
+func lineError(target, current *image.RGBA, weights *image.Gray, y, x1, x2 int) uint64 {
+	var total uint64
+	i := target.PixOffset(x1, y)
+	j := -1
+	if weights != nil {
+		j = weights.PixOffset(x1, y)
+	}
+	for x := x1; x <= x2; x++ {
+		dr := int(target.Pix[i]) - int(current.Pix[i])
+		dg := int(target.Pix[i+1]) - int(current.Pix[i+1])
+		db := int(target.Pix[i+2]) - int(current.Pix[i+2])
+		da := int(target.Pix[i+3]) - int(current.Pix[i+3])
+		e := uint64(dr*dr + dg*dg + db*db + da*da)
+		if j < 0 {
+			total += e * 255
+		} else {
+			total += e * uint64(weights.Pix[j])
+			j++
+		}
+		i += 4
+	}
+	return total
+}
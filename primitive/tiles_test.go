@@ -0,0 +1,128 @@
+package primitive
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+)
+
+// randomRGBA returns an opaque image of random pixels.
+func randomRGBA(rnd *rand.Rand, w, h int) *image.RGBA {
+	im := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := range im.Pix {
+		im.Pix[i] = uint8(rnd.Intn(256))
+		if i%4 == 3 {
+			im.Pix[i] = 255
+		}
+	}
+	return im
+}
+
+// randomGray returns weights of random pixels.
+func randomGray(rnd *rand.Rand, w, h int) *image.Gray {
+	im := image.NewGray(image.Rect(0, 0, w, h))
+	for i := range im.Pix {
+		im.Pix[i] = uint8(rnd.Intn(256))
+	}
+	return im
+}
+
+// randomLines returns a few random scanlines inside a w by h image, no two
+// on the same row.
+func randomLines(rnd *rand.Rand, w, h int) []Scanline {
+	var lines []Scanline
+	for y := 0; y < h; y++ {
+		if rnd.Intn(3) != 0 {
+			continue
+		}
+		x1 := rnd.Intn(w)
+		x2 := x1 + rnd.Intn(w-x1)
+		lines = append(lines, Scanline{y, x1, x2, 0xffff})
+	}
+	return lines
+}
+
+// redraw returns a copy of im with random colors under lines.
+func redraw(rnd *rand.Rand, im *image.RGBA, lines []Scanline) *image.RGBA {
+	after := copyRGBA(im)
+	for _, line := range lines {
+		c := Color{rnd.Intn(256), rnd.Intn(256), rnd.Intn(256), 255}
+		drawLines(after, c, []Scanline{line})
+	}
+	return after
+}
+
+func TestTilesUpdate(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	// sizes that are not multiples of the tile size
+	w, h := 50, 37
+	target := randomRGBA(rnd, w, h)
+	current := randomRGBA(rnd, w, h)
+	for _, weights := range []*image.Gray{nil, randomGray(rnd, w, h)} {
+		tiles := NewTiles(target, current, weights)
+		before := current
+		for i := 0; i < 10; i++ {
+			lines := randomLines(rnd, w, h)
+			after := redraw(rnd, before, lines)
+			tiles.Update(target, before, after, lines)
+			before = after
+		}
+		want := NewTiles(target, before, weights)
+		if tiles.Total != want.Total {
+			t.Errorf("total %d, want %d", tiles.Total, want.Total)
+		}
+		for i := range want.Errors {
+			if tiles.Errors[i] != want.Errors[i] {
+				t.Errorf("tile %d: error %d, want %d", i, tiles.Errors[i], want.Errors[i])
+			}
+		}
+	}
+}
+
+func TestTilesSample(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	w, h := 64, 40
+	target := image.NewRGBA(image.Rect(0, 0, w, h))
+	current := image.NewRGBA(image.Rect(0, 0, w, h))
+	// error only in the tiles at columns 1 and 3 of row 1, the second with
+	// three times the error of the first
+	for y := 16; y < 32; y++ {
+		for x := 16; x < 32; x++ {
+			target.Pix[target.PixOffset(x, y)] = 100
+		}
+		for x := 48; x < 64; x++ {
+			target.Pix[target.PixOffset(x, y)] = 173
+		}
+	}
+	tiles := NewTiles(target, current, nil)
+	const n = 20000
+	var right int
+	for i := 0; i < n; i++ {
+		x, y := tiles.Sample(rnd)
+		switch {
+		case y < 16 || y >= 32:
+			t.Fatalf("sampled %d, %d outside the tiles with error", x, y)
+		case x >= 16 && x < 32:
+		case x >= 48 && x < 64:
+			right++
+		default:
+			t.Fatalf("sampled %d, %d outside the tiles with error", x, y)
+		}
+	}
+	// 173^2 is about three times 100^2
+	if f := float64(right) / n; f < 0.72 || f > 0.78 {
+		t.Errorf("sampled the larger error %.3f of the time, want about 0.75", f)
+	}
+}
+
+func TestTilesSampleUniform(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	im := randomRGBA(rnd, 20, 10)
+	tiles := NewTiles(im, im, nil)
+	for i := 0; i < 1000; i++ {
+		x, y := tiles.Sample(rnd)
+		if x < 0 || x >= 20 || y < 0 || y >= 10 {
+			t.Fatalf("sampled %d, %d outside the image", x, y)
+		}
+	}
+}
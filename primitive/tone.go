@@ -0,0 +1,225 @@
+package primitive
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"strings"
+)
+
+// Tone restricts the output to a ramp between a dark and a light color:
+// black to white for grayscale or two arbitrary colors for a duotone. The
+// target is mapped onto the ramp by luminance and shape colors are solved
+// for their position on the ramp only. A monochrome tone allows just the
+// two end colors.
+type Tone struct {
+	Dark, Light Color
+	Mono        bool
+}
+
+// ParseTone parses a tone: gray, duotone:DARK,LIGHT, mono or
+// mono:DARK,LIGHT, where DARK and LIGHT are hex colors.
+func ParseTone(s string) (*Tone, error) {
+	name, colors := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		name, colors = s[:i], s[i+1:]
+	}
+	tone := &Tone{Color{0, 0, 0, 255}, Color{255, 255, 255, 255}, false}
+	switch name {
+	case "gray":
+		if colors != "" {
+			return nil, fmt.Errorf("gray tone takes no colors: %s", s)
+		}
+		return tone, nil
+	case "duotone":
+		if colors == "" {
+			return nil, fmt.Errorf("duotone requires two colors, e.g. duotone:#1d3557,#f1faee")
+		}
+	case "mono":
+		tone.Mono = true
+	default:
+		return nil, fmt.Errorf("unrecognized tone: %s", s)
+	}
+	if colors != "" {
+		palette, err := ParsePalette(colors)
+		if err != nil {
+			return nil, err
+		}
+		if len(palette) != 2 {
+			return nil, fmt.Errorf("%s tone requires two colors, got %d", name, len(palette))
+		}
+		tone.Dark, tone.Light = palette[0], palette[1]
+		tone.Dark.A, tone.Light.A = 255, 255
+		if tone.Dark == tone.Light {
+			return nil, fmt.Errorf("%s tone colors must differ", name)
+		}
+	}
+	return tone, nil
+}
+
+// ramp returns the color at position t in [0, 1] along the ramp.
+func (tone *Tone) ramp(t float64, alpha int) Color {
+	t = clamp(t, 0, 1)
+	lerp := func(a, b int) int {
+		return int(float64(a) + t*float64(b-a) + 0.5)
+	}
+	d, l := tone.Dark, tone.Light
+	return Color{lerp(d.R, l.R), lerp(d.G, l.G), lerp(d.B, l.B), alpha}
+}
+
+// Image maps an image onto the ramp by luminance, keeping its alpha.
+func (tone *Tone) Image(im image.Image) image.Image {
+	dst := image.NewNRGBA(im.Bounds())
+	draw.Draw(dst, dst.Rect, im, im.Bounds().Min, draw.Src)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		p := dst.Pix[i : i+4]
+		y := (0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])) / 255
+		c := tone.ramp(y, int(p[3]))
+		p[0], p[1], p[2] = uint8(c.R), uint8(c.G), uint8(c.B)
+	}
+	return dst
+}
+
+// project returns the color on the ramp nearest to c, with the alpha of c.
+func (tone *Tone) project(c Color) Color {
+	d, l := tone.Dark, tone.Light
+	dr, dg, db := float64(l.R-d.R), float64(l.G-d.G), float64(l.B-d.B)
+	t := (float64(c.R-d.R)*dr + float64(c.G-d.G)*dg + float64(c.B-d.B)*db) /
+		(dr*dr + dg*dg + db*db)
+	return tone.ramp(t, c.A)
+}
+
+// palette returns the colors a monochrome tone is restricted to.
+func (tone *Tone) palette() Palette {
+	return Palette{tone.Dark, tone.Light}
+}
+
+// Metric returns a metric equivalent to RGBMetric for images on the ramp
+// that reads a single color channel. Every channel is an affine function of
+// the position on the ramp, so the channel that changes the most along it
+// determines the error of the others.
+func (tone *Tone) Metric() Metric {
+	d, l := tone.Dark, tone.Light
+	deltas := [3]float64{float64(l.R - d.R), float64(l.G - d.G), float64(l.B - d.B)}
+	m := &ToneMetric{tone: tone}
+	var sum float64
+	for i, v := range deltas {
+		sum += v * v
+		if math.Abs(v) > math.Abs(deltas[m.channel]) {
+			m.channel = i
+		}
+	}
+	m.scale = sum / (deltas[m.channel] * deltas[m.channel])
+	return m
+}
+
+// ToneMetric is the root mean square error of a tone's ramp position, scaled
+// to match RGBMetric. See Tone.Metric.
+type ToneMetric struct {
+	tone    *Tone
+	channel int
+	scale   float64
+}
+
+func (m *ToneMetric) lineError(target, current *image.RGBA, weights *image.Gray, y, x1, x2 int) float64 {
+	var total float64
+	i := target.PixOffset(x1, y)
+	j := -1
+	if weights != nil {
+		j = weights.PixOffset(x1, y)
+	}
+	c := m.channel
+	for x := x1; x <= x2; x++ {
+		dc := float64(int(target.Pix[i+c]) - int(current.Pix[i+c]))
+		da := float64(int(target.Pix[i+3]) - int(current.Pix[i+3]))
+		e := dc*dc*m.scale + da*da
+		if j < 0 {
+			total += e
+		} else {
+			total += e * float64(weights.Pix[j]) / 255
+			j++
+		}
+		i += 4
+	}
+	return total
+}
+
+func (m *ToneMetric) Total(target, current *image.RGBA, weights *image.Gray) float64 {
+	size := target.Bounds().Size()
+	var total float64
+	for y := 0; y < size.Y; y++ {
+		total += m.lineError(target, current, weights, y, 0, size.X-1)
+	}
+	return total
+}
+
+func (m *ToneMetric) Delta(target, before, after *image.RGBA, weights *image.Gray, lines []Scanline) float64 {
+	var delta float64
+	for _, line := range lines {
+		delta -= m.lineError(target, before, weights, line.Y, line.X1, line.X2)
+		delta += m.lineError(target, after, weights, line.Y, line.X1, line.X2)
+	}
+	return delta
+}
+
+func (m *ToneMetric) Score(total, weight float64) float64 {
+	return math.Sqrt(math.Max(total, 0)/(weight*4)) / 255
+}
+
+// Color solves for the ramp position on the metric's channel alone, like
+// computeColor does for each channel.
+func (m *ToneMetric) Color(target, current *image.RGBA, weights *image.Gray, lines []Scanline, alpha int) Color {
+	var sum, count int64
+	a := 0x101 * 255 / alpha
+	c := m.channel
+	for _, line := range lines {
+		i := target.PixOffset(line.X1, line.Y)
+		j := -1
+		if weights != nil {
+			j = weights.PixOffset(line.X1, line.Y)
+		}
+		for x := line.X1; x <= line.X2; x++ {
+			tc := int(target.Pix[i+c])
+			ta := target.Pix[i+3]
+			cc := int(current.Pix[i+c])
+			if ca := int(current.Pix[i+3]); ta != 0 && (ta != 255 || ca != 255) {
+				oa := alpha + ca*(255-alpha)/255
+				tc = tc * oa / int(ta)
+			}
+			i += 4
+			w := int64(1)
+			if j >= 0 {
+				w = int64(weights.Pix[j])
+				j++
+			}
+			if ta == 0 {
+				w = 0
+			}
+			sum += w * int64((tc-cc)*a+cc*0x101)
+			count += w
+		}
+	}
+	if count == 0 {
+		return Color{}
+	}
+	v := float64(clampInt(int(sum/count)>>8, 0, 255))
+	d, l := m.tone.Dark, m.tone.Light
+	ends := [3][2]int{{d.R, l.R}, {d.G, l.G}, {d.B, l.B}}[c]
+	return m.tone.ramp((v-float64(ends[0]))/float64(ends[1]-ends[0]), alpha)
+}
+
+// SetTone restricts the colors of shapes added from now on to the tone's
+// ramp, or to its two end colors for a monochrome tone. The target should
+// already be mapped with Tone.Image. A nil tone lifts the restriction.
+func (model *Model) SetTone(tone *Tone) {
+	model.Tone = tone
+	for _, worker := range model.Workers {
+		for w := worker; w != nil; w = w.Coarse {
+			w.Tone = tone
+		}
+	}
+	if tone != nil && tone.Mono {
+		model.SetPalette(tone.palette())
+	}
+}
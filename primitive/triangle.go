@@ -16,12 +16,12 @@ type Triangle struct {
 
 func NewRandomTriangle(worker *Worker) *Triangle {
 	rnd := worker.Rnd
-	x1 := rnd.Intn(worker.W)
-	y1 := rnd.Intn(worker.H)
-	x2 := x1 + rnd.Intn(31) - 15
-	y2 := y1 + rnd.Intn(31) - 15
-	x3 := x1 + rnd.Intn(31) - 15
-	y3 := y1 + rnd.Intn(31) - 15
+	x1, y1 := worker.RandomPoint()
+	d := int(worker.maxSize(15, 2))
+	x2 := x1 + rnd.Intn(2*d+1) - d
+	y2 := y1 + rnd.Intn(2*d+1) - d
+	x3 := x1 + rnd.Intn(2*d+1) - d
+	y3 := y1 + rnd.Intn(2*d+1) - d
 	t := &Triangle{worker, x1, y1, x2, y2, x3, y3}
 	t.Mutate()
 	return t
@@ -52,17 +52,18 @@ func (t *Triangle) Mutate() {
 	h := t.Worker.H
 	rnd := t.Worker.Rnd
 	const m = 16
+	d := t.Worker.mutation(16)
 	for {
 		switch rnd.Intn(3) {
 		case 0:
-			t.X1 = clampInt(t.X1+int(rnd.NormFloat64()*16), -m, w-1+m)
-			t.Y1 = clampInt(t.Y1+int(rnd.NormFloat64()*16), -m, h-1+m)
+			t.X1 = clampInt(t.X1+int(rnd.NormFloat64()*d), -m, w-1+m)
+			t.Y1 = clampInt(t.Y1+int(rnd.NormFloat64()*d), -m, h-1+m)
 		case 1:
-			t.X2 = clampInt(t.X2+int(rnd.NormFloat64()*16), -m, w-1+m)
-			t.Y2 = clampInt(t.Y2+int(rnd.NormFloat64()*16), -m, h-1+m)
+			t.X2 = clampInt(t.X2+int(rnd.NormFloat64()*d), -m, w-1+m)
+			t.Y2 = clampInt(t.Y2+int(rnd.NormFloat64()*d), -m, h-1+m)
 		case 2:
-			t.X3 = clampInt(t.X3+int(rnd.NormFloat64()*16), -m, w-1+m)
-			t.Y3 = clampInt(t.Y3+int(rnd.NormFloat64()*16), -m, h-1+m)
+			t.X3 = clampInt(t.X3+int(rnd.NormFloat64()*d), -m, w-1+m)
+			t.Y3 = clampInt(t.Y3+int(rnd.NormFloat64()*d), -m, h-1+m)
 		}
 		if t.Valid() {
 			break
@@ -114,6 +115,22 @@ func (t *Triangle) Area() float64 {
 	return math.Abs(float64((t.X2 - t.X1)*(t.Y3 - t.Y1) - (t.X3 - t.X1)*(t.Y2 - t.Y1)))/2.0
 }
 
+func (t *Triangle) Rescale(worker *Worker, factor float64) Shape {
+	a := *t
+	a.Worker = worker
+	a.X1, a.Y1 = scaleInt(t.X1, factor), scaleInt(t.Y1, factor)
+	a.X2, a.Y2 = scaleInt(t.X2, factor), scaleInt(t.Y2, factor)
+	a.X3, a.Y3 = scaleInt(t.X3, factor), scaleInt(t.Y3, factor)
+	return &a
+}
+
+func (t *Triangle) Transform(tr Transform) Shape {
+	a := *t
+	a.X1, a.Y1 = tr.pointInt(t.Worker, t.X1, t.Y1)
+	a.X2, a.Y2 = tr.pointInt(t.Worker, t.X2, t.Y2)
+	a.X3, a.Y3 = tr.pointInt(t.Worker, t.X3, t.Y3)
+	return &a
+}
 
 func rasterizeTriangle(x1, y1, x2, y2, x3, y3 int, buf []Scanline) []Scanline {
 	if y1 > y3 {
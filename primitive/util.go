@@ -161,6 +161,10 @@ func maxInt(a, b int) int {
 	return b
 }
 
+func scaleInt(x int, factor float64) int {
+	return int(math.Floor(float64(x)*factor + 0.5))
+}
+
 func rotate(x, y, theta float64) (rx, ry float64) {
 	rx = x*math.Cos(theta) - y*math.Sin(theta)
 	ry = x*math.Sin(theta) + y*math.Cos(theta)
@@ -189,17 +193,23 @@ func AverageImageColor(im image.Image) color.NRGBA {
 	rgba := imageToRGBA(im)
 	size := rgba.Bounds().Size()
 	w, h := size.X, size.Y
-	var r, g, b int
+	// colors are premultiplied, so dividing by the total alpha averages the
+	// visible pixels only
+	var r, g, b, a int
 	for y := 0; y < h; y++ {
 		for x := 0; x < w; x++ {
 			c := rgba.RGBAAt(x, y)
 			r += int(c.R)
 			g += int(c.G)
 			b += int(c.B)
+			a += int(c.A)
 		}
 	}
-	r /= w * h
-	g /= w * h
-	b /= w * h
+	if a == 0 {
+		return color.NRGBA{0, 0, 0, 255}
+	}
+	r = r * 255 / a
+	g = g * 255 / a
+	b = b * 255 / a
 	return color.NRGBA{uint8(r), uint8(g), uint8(b), 255}
 }
@@ -0,0 +1,102 @@
+package primitive
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+)
+
+// Weights give the importance of each pixel of the target as a grayscale
+// image: a value of 255 counts a pixel fully and 0 ignores it. A nil weight
+// image weighs every pixel fully.
+
+// WeightsFromImage converts an image to weights using its luminance.
+func WeightsFromImage(im image.Image) *image.Gray {
+	dst := image.NewGray(im.Bounds())
+	draw.Draw(dst, dst.Rect, im, im.Bounds().Min, draw.Src)
+	return dst
+}
+
+// SetWeights sets the importance of each pixel when measuring error and
+// solving for colors. weights must have the same bounds as the target.
+func (model *Model) SetWeights(weights *image.Gray) error {
+	if weights != nil && weights.Bounds() != model.Target.Bounds() {
+		return fmt.Errorf("weights are %v but the target is %v", weights.Bounds(), model.Target.Bounds())
+	}
+	weights = model.visibleWeights(weights)
+	if weights != nil && weightSum(weights, weights.Bounds()) == 0 {
+		return fmt.Errorf("weights are zero everywhere")
+	}
+	model.Weights = weights
+	model.refresh()
+	return nil
+}
+
+// visibleWeights zeroes the weights of fully transparent target pixels, so
+// that no shapes are spent on them. With a transparent background they keep
+// their weight instead, as leaving them uncovered is what preserves the
+// transparency.
+func (model *Model) visibleWeights(weights *image.Gray) *image.Gray {
+	if model.Background.A == 0 {
+		return weights
+	}
+	target := model.Target
+	var result *image.Gray
+	for i := 3; i < len(target.Pix); i += 4 {
+		if target.Pix[i] != 0 {
+			continue
+		}
+		if result == nil {
+			if weights == nil {
+				result = image.NewGray(target.Bounds())
+				for j := range result.Pix {
+					result.Pix[j] = 255
+				}
+			} else {
+				result = image.NewGray(weights.Bounds())
+				copy(result.Pix, weights.Pix)
+			}
+		}
+		result.Pix[i/4] = 0
+	}
+	if result == nil || weightSum(result, result.Bounds()) == 0 {
+		return weights
+	}
+	return result
+}
+
+// weightSum returns the total weight of the pixels in bounds.
+func weightSum(weights *image.Gray, bounds image.Rectangle) float64 {
+	if weights == nil {
+		return float64(bounds.Dx() * bounds.Dy())
+	}
+	var total int
+	for _, w := range weights.Pix {
+		total += int(w)
+	}
+	return float64(total) / 255
+}
+
+// halveGray returns a copy of src at half its width and height.
+func halveGray(src *image.Gray) *image.Gray {
+	size := src.Bounds().Size()
+	dst := image.NewGray(image.Rect(0, 0, size.X/2, size.Y/2))
+	downsampleGrayRect(dst, src, dst.Bounds())
+	return dst
+}
+
+// downsampleGrayRect fills r of dst by averaging 2x2 blocks of src.
+func downsampleGrayRect(dst, src *image.Gray, r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := dst.PixOffset(r.Min.X, y)
+		j := src.PixOffset(r.Min.X*2, y*2)
+		k := src.PixOffset(r.Min.X*2, y*2+1)
+		for x := r.Min.X; x < r.Max.X; x++ {
+			sum := int(src.Pix[j]) + int(src.Pix[j+1]) + int(src.Pix[k]) + int(src.Pix[k+1])
+			dst.Pix[i] = uint8((sum + 2) / 4)
+			i++
+			j += 2
+			k += 2
+		}
+	}
+}
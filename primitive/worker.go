@@ -2,6 +2,7 @@ package primitive
 
 import (
 	"image"
+	"math"
 	"math/rand"
 	"time"
 	// "fmt"
@@ -16,15 +17,49 @@ type Worker struct {
 	Buffer     *image.RGBA
 	Rasterizer *raster.Rasterizer
 	Lines      []Scanline
-	Heatmap    *Heatmap
+	Tiles      *Tiles
 	Rnd        *rand.Rand
+	Metric     Metric
+	Weights    *image.Gray
+	Weight     float64
+	Region     *image.Gray
+	Linear     bool
+	Blend      BlendMode
+	Palette    Palette
+	Tone       *Tone
+	Symmetry   Symmetry
+	Orientation *Orientation
+	Schedule   *SizeSchedule
+	Lattice    *Lattice
+	Occupancy  *image.Gray
+	Overlap    float64
+	MixturePolicy MixturePolicy
+	Total      float64
 	Score      float64
 	BlackThresh float64
 	LowerAreaThresh float64
 	UpperAreaThresh float64
+	Deadline   time.Time
+	MutateScale float64
+	// size of a pixel of this worker in full resolution pixels, so that
+	// coarse workers make shapes of the same size as the full resolution one
+	SizeScale  float64
+	Coarse     *Worker
+	Placement  Placement
 	Counter    int
 }
 
+// Placement selects how positions for new random shapes are chosen.
+type Placement int
+
+const (
+	// PlacementUniform picks positions uniformly over the image.
+	PlacementUniform Placement = iota
+	// PlacementError favors positions where the current image differs most
+	// from the target.
+	PlacementError
+)
+
 func NewWorker(target *image.RGBA, blackThresh, lowerAreaThresh, upperAreaThresh float64, seed int64) *Worker {
 	w := target.Bounds().Size().X
 	h := target.Bounds().Size().Y
@@ -35,35 +70,85 @@ func NewWorker(target *image.RGBA, blackThresh, lowerAreaThresh, upperAreaThresh
 	worker.Buffer = image.NewRGBA(target.Bounds())
 	worker.Rasterizer = raster.NewRasterizer(w, h)
 	worker.Lines = make([]Scanline, 0, 4096) // TODO: based on height
-	worker.Heatmap = NewHeatmap(w, h)
 	if seed == -1 {
 		seed = time.Now().UnixNano()
 	}
 	vv("NewWorker: seed=%d\n", seed)
 	worker.Rnd = rand.New(rand.NewSource(seed))
+	worker.Metric = &RGBMetric{}
+	worker.Weight = float64(w * h)
 	worker.BlackThresh = blackThresh
 	worker.UpperAreaThresh = upperAreaThresh
 	worker.LowerAreaThresh = lowerAreaThresh
+	worker.MutateScale = 1
+	worker.SizeScale = 1
 	vv("NewWorker: BlackThresh=%.2f, LowerAreaThresh=%.2f, UpperAreaThresh=%.2f\n", worker.BlackThresh, worker.LowerAreaThresh, worker.UpperAreaThresh)
 	return &worker
 }
 
-func (worker *Worker) Init(current *image.RGBA, score float64) {
+func (worker *Worker) Init(current *image.RGBA, total float64) {
 	worker.Current = current
-	worker.Score = score
+	worker.Total = total
+	worker.Score = worker.Metric.Score(total, worker.Weight)
 	worker.Counter = 0
-	worker.Heatmap.Clear()
 }
 
+// RandomPoint returns a position for a new random shape according to the
+// worker's placement strategy, inside the region and the space left between
+// packed shapes when they are set.
+func (worker *Worker) RandomPoint() (x, y int) {
+	for i := 0; ; i++ {
+		if worker.Placement == PlacementError && worker.Tiles != nil {
+			x, y = worker.Tiles.Sample(worker.Rnd)
+		} else {
+			x, y = worker.Rnd.Intn(worker.W), worker.Rnd.Intn(worker.H)
+		}
+		if i >= regionTries || inRegion(worker.Region, x, y) && free(worker.Occupancy, x, y) {
+			return
+		}
+	}
+}
+
+// RandomPointF is like RandomPoint but returns a continuous position.
+func (worker *Worker) RandomPointF() (x, y float64) {
+	for i := 0; ; i++ {
+		if worker.Placement == PlacementError && worker.Tiles != nil {
+			px, py := worker.Tiles.Sample(worker.Rnd)
+			x, y = float64(px)+worker.Rnd.Float64(), float64(py)+worker.Rnd.Float64()
+		} else {
+			x, y = worker.Rnd.Float64()*float64(worker.W), worker.Rnd.Float64()*float64(worker.H)
+		}
+		if i >= regionTries || inRegion(worker.Region, int(x), int(y)) && free(worker.Occupancy, int(x), int(y)) {
+			return
+		}
+	}
+}
+
+// mutation returns the standard deviation to use for a mutation whose
+// nominal size is sigma full resolution pixels, scaled by the current
+// adaptive mutation scale and converted to the worker's pixels.
+func (worker *Worker) mutation(sigma float64) float64 {
+	return math.Max(sigma*worker.MutateScale*worker.SizeScale, 1)
+}
+
+// rejected is the energy of shapes that may not be added. Scores under
+// metrics other than rgb are not bounded by 1, so it must lie above all of
+// them.
+var rejected = math.Inf(1)
+
 func (worker *Worker) Energy(shape Shape, alpha int) float64 {
 	black := Color{0, 0, 0, alpha}
 	worker.Counter++
 	lines := shape.Rasterize()
-	// worker.Heatmap.Add(lines)
-	color := computeColor(worker.Target, worker.Current, lines, alpha)
-	diff := RGBADiffColor(color, black)
-	if diff < worker.BlackThresh {
-		return 1.0
+	var copies [][]Scanline
+	if len(worker.Symmetry) > 0 {
+		copies, lines = rasterizeCopies(worker.Symmetry.copies(shape))
+	}
+	if !linesInRegion(worker.Region, lines) {
+		return rejected
+	}
+	if !fits(worker.Occupancy, worker.Overlap, lines) {
+		return rejected
 	}
 	if worker.UpperAreaThresh > 0.0 {
 		area := shape.Area()
@@ -72,16 +157,38 @@ func (worker *Worker) Energy(shape Shape, alpha int) float64 {
 			frac := area / total_area
 			if frac > worker.UpperAreaThresh || frac < worker.LowerAreaThresh {
 				// vv("Energy: area=%.2f, total area=%.2f, frac=%.2f\n", area, total_area, frac)
-				return 1.0
+				return rejected
 			} else {
 				vv("Energy: area=%.2f, total area=%.2f, frac=%.2f\n", area, total_area, frac)
 			}
 		}
 	}
 
+	color := blendColor(worker.Metric, worker.Blend, worker.Linear, worker.Target, worker.Current, worker.Weights, lines, alpha)
+	if worker.Tone != nil {
+		color = worker.Tone.project(color)
+	}
+	var total float64
+	if len(worker.Palette) > 0 {
+		color, total = pickPaletteColor(worker.Palette, color, func(c Color) float64 {
+			return worker.total(lines, copies, c)
+		})
+	} else {
+		total = worker.total(lines, copies, color)
+	}
+	diff := RGBADiffColor(color, black)
+	if diff < worker.BlackThresh {
+		return rejected
+	}
+	return worker.Metric.Score(total, worker.Weight)
+}
+
+// total returns the total error after drawing lines, or the symmetric copies
+// covering them, with the given color, leaving the result in the buffer.
+func (worker *Worker) total(lines []Scanline, copies [][]Scanline, color Color) float64 {
 	copyLines(worker.Buffer, worker.Current, lines)
-	drawLines(worker.Buffer, color, lines)
-	return differencePartial(worker.Target, worker.Current, worker.Buffer, worker.Score, lines)
+	drawCopies(worker.Buffer, color, lines, copies, worker.Blend, worker.Linear)
+	return worker.Total + worker.Metric.Delta(worker.Target, worker.Current, worker.Buffer, worker.Weights, lines)
 }
 
 func (worker *Worker) BestHillClimbState(t ShapeType, a, n, age, m, idx int, fn NewShapeFunc, rand_val float64) *State {
@@ -90,13 +197,12 @@ func (worker *Worker) BestHillClimbState(t ShapeType, a, n, age, m, idx int, fn
 	// rand_val := worker.Rnd.Float64()
 	v("BestHillClimbState: n=%d, m=%d, r=%f\n", n, m, rand_val)
 	for i := 0; i < m; i++ {
-		state := worker.BestRandomState(t, a, n, idx, fn, rand_val)
-		before := state.Energy()
-		area_before := state.Shape.Area()
-		state = HillClimb(state, age).(*State)
+		// always finish at least one search so the step has a result
+		if i > 0 && !worker.Deadline.IsZero() && time.Now().After(worker.Deadline) {
+			break
+		}
+		state := worker.search(t, a, n, age, idx, fn, rand_val)
 		energy := state.Energy()
-		area_after := state.Shape.Area()
-		vv("%dx random: %.6f -> %dx hill climb: %.6f (area %.1f -> %.1f)\n", n, before, age, energy, area_before, area_after)
 		if i == 0 || energy < bestEnergy {
 			bestEnergy = energy
 			bestState = state
@@ -105,6 +211,35 @@ func (worker *Worker) BestHillClimbState(t ShapeType, a, n, age, m, idx int, fn
 	return bestState
 }
 
+const refineAgeDivisor = 4
+
+// search samples random shapes and hill climbs the best one. With a pyramid,
+// the sampling and first hill climb happen on the coarsest level and the
+// result is rescaled and refined at each finer level.
+func (worker *Worker) search(t ShapeType, a, n, age, idx int, fn NewShapeFunc, rand_val float64) *State {
+	var state *State
+	if worker.Coarse == nil {
+		state = worker.BestRandomState(t, a, n, idx, fn, rand_val)
+	} else {
+		coarse := worker.Coarse.search(t, a, n, age, idx, fn, rand_val)
+		// not W/Coarse.W, which differs between the axes for odd sizes
+		factor := float64(levelScale)
+		state = NewState(worker, coarse.Shape.Rescale(worker, factor), coarse.Alpha)
+		state.MutateAlpha = coarse.MutateAlpha
+		state.Entry = coarse.Entry
+		// the coarse search already did most of the work, so finer levels
+		// only need a short refinement
+		age = maxInt(age/refineAgeDivisor, 1)
+	}
+	before := state.Energy()
+	area_before := state.Shape.Area()
+	state = HillClimb(state, age).(*State)
+	energy := state.Energy()
+	area_after := state.Shape.Area()
+	vv("%dx%d: %dx random: %.6f -> %dx hill climb: %.6f (area %.1f -> %.1f)\n", worker.W, worker.H, n, before, age, energy, area_before, area_after)
+	return state
+}
+
 func (worker *Worker) BestRandomState(t ShapeType, a, n, idx int, fn NewShapeFunc, rand_val float64) *State {
 	var bestEnergy float64
 	var bestState *State
@@ -121,28 +256,31 @@ func (worker *Worker) BestRandomState(t ShapeType, a, n, idx int, fn NewShapeFun
 	return bestState
 }
 
-func NewBlueDotSessionsShapeFactory (modes []int, percs []float64) NewShapeFunc {
+func NewBlueDotSessionsShapeFactory (mixture Mixture) NewShapeFunc {
 	var cum_percs []float64
 	cur_cum_val := 0.0
-	for idx, _ := range percs {
-		cur_cum_val += percs[idx]
+	for idx, _ := range mixture {
+		cur_cum_val += mixture[idx].Weight
 		cum_percs = append(cum_percs, cur_cum_val)
 	}
 	// fmt.Println(cum_percs)
-	return func (worker *Worker, a, idx int, rand_val float64) Shape {
+	return func (worker *Worker, a, idx int, rand_val float64) (Shape, *MixtureEntry) {
 		// vv("NewBlueDotSessionsShapeFactory")
 		// rnd := worker.Rnd
 		// rand_val := rnd.Float64()
 		for idy, val := range cum_percs {
 			if rand_val <= val {
-				return worker.SimpleRandomShape(ShapeType(modes[idy]))
+				return mixture[idy].randomShape(worker), &mixture[idy]
 			}
 		}
-		return worker.SimpleRandomShape(ShapeType(modes[len(modes) - 1]))
+		entry := &mixture[len(mixture) - 1]
+		return entry.randomShape(worker), entry
 	}
 }
 
-type NewShapeFunc func(worker* Worker, a, idx int, rand_val float64) Shape
+// NewShapeFunc returns a new random shape for a mixture along with the
+// entry of the mixture it was drawn from.
+type NewShapeFunc func(worker* Worker, a, idx int, rand_val float64) (Shape, *MixtureEntry)
 
 func (worker *Worker) SimpleRandomShape(t ShapeType) Shape {
 	switch t {
@@ -168,6 +306,8 @@ func (worker *Worker) SimpleRandomShape(t ShapeType) Shape {
 		return NewRandomRFTriangle(worker)
 	case ShapeTypeDiamond:
 		return NewRandomDiamond(worker, 4, true, 15, 20, 0)
+	case ShapeTypeTile:
+		return NewRandomTile(worker)
 	}
 }
 
@@ -175,7 +315,16 @@ func (worker *Worker) SimpleRandomShape(t ShapeType) Shape {
 func (worker *Worker) RandomState(t ShapeType, a, idx int, fn NewShapeFunc, rand_val float64) *State {
 	vv("RandomState: idx=%d\n", idx)
 	if t == ShapeTypeBlueDotSessions {
-		return NewState(worker, fn(worker, a, idx, rand_val), a)
+		if worker.MixturePolicy == MixturePerCandidate {
+			rand_val = worker.Rnd.Float64()
+		}
+		shape, entry := fn(worker, a, idx, rand_val)
+		if entry.Alpha > 0 {
+			a = entry.Alpha
+		}
+		state := NewState(worker, shape, a)
+		state.Entry = entry
+		return state
 	} else {
 		return NewState(worker, worker.SimpleRandomShape(t), a)
 	}